@@ -0,0 +1,110 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========admin set records===============
+var adminsKey = "cfg:admins"
+
+//============end==========admin set records===============
+
+// getAdmins returns the admin set. Ledgers set up before the set was kept treat every
+// ADMIN account as an admin.
+func getAdmins(stub shim.ChaincodeStubInterface) ([]string, error) {
+	admins := []string{}
+	adminsBytes, err := stub.GetState(adminsKey)
+	if err != nil {
+		return nil, errors.New("Error reading admins")
+	}
+	if len(adminsBytes) > 0 {
+		err = json.Unmarshal(adminsBytes, &admins)
+		if err != nil {
+			return nil, errors.New("Error unmarshalling admins")
+		}
+		return admins, nil
+	}
+
+	err = scanPrefix(stub, accountPrefix, func(key string, value []byte) error {
+		var account Account
+		err := json.Unmarshal(value, &account)
+		if err != nil {
+			return errors.New("Error unmarshalling account " + key)
+		}
+		if accountType(account) == "ADMIN" {
+			admins = append(admins, account.ID)
+		}
+		return nil
+	})
+	return admins, err
+}
+
+func putAdmins(stub shim.ChaincodeStubInterface, admins []string) error {
+	adminsBytes, err := json.Marshal(admins)
+	if err != nil {
+		return errors.New("Error marshalling admins")
+	}
+	err = stub.PutState(adminsKey, adminsBytes)
+	if err != nil {
+		return errors.New("Error writing admins")
+	}
+	return nil
+}
+
+// isAdmin checks an account is an ADMIN account in the admin set
+func isAdmin(stub shim.ChaincodeStubInterface, accountID string) (bool, error) {
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return false, err
+	}
+	return listContains(admins, accountID), nil
+}
+
+// openAdminAccount creates an empty ADMIN account and adds it to the admin set
+func openAdminAccount(stub shim.ChaincodeStubInterface, accountID string) ([]byte, error) {
+	existingBytes, err := stub.GetState(accountPrefix + accountID)
+	if err != nil {
+		return nil, errors.New("Error while obtaining existing account " + accountID)
+	}
+	if len(existingBytes) > 0 {
+		return nil, errors.New("Account already existing for user " + accountID)
+	}
+
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+	account := Account{ID: accountID, Prefix: accountID + "000A"}
+	err = putAccount(stub, account)
+	if err != nil {
+		return nil, err
+	}
+	err = putAdmins(stub, append(admins, accountID))
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("============created admin account" + accountPrefix + accountID)
+	return json.Marshal(&account)
+}
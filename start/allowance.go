@@ -0,0 +1,248 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========allowance records===============
+var allowancePrefix = "allowance:"
+
+// Allowance lets a spender pay out of the owner's account until Remaining is used up or
+// the allowance expires. Nothing is held, so the owner's balance still has to cover each spend.
+type Allowance struct {
+	Owner     string  `json:"owner"`
+	Spender   string  `json:"spender"`
+	Amount    float64 `json:"amount"`
+	Remaining float64 `json:"remaining"`
+	Expiry    int64   `json:"expiry"`
+	GrantedAt int64   `json:"grantedAt"`
+	Expired   bool    `json:"expired,omitempty"`
+}
+
+//============end==========allowance records===============
+
+func allowanceKey(owner string, spender string) string {
+	return allowancePrefix + owner + ":" + spender
+}
+
+func getAllowance(stub shim.ChaincodeStubInterface, owner string, spender string) (Allowance, error) {
+	var allowance Allowance
+	allowanceBytes, err := stub.GetState(allowanceKey(owner, spender))
+	if err != nil || len(allowanceBytes) == 0 {
+		fmt.Println("No allowance from " + owner + " to " + spender)
+		return allowance, errors.New("No allowance from " + owner + " to " + spender)
+	}
+
+	err = json.Unmarshal(allowanceBytes, &allowance)
+	if err != nil {
+		fmt.Println("Error unmarshalling allowance " + owner + ":" + spender + "\n err:" + err.Error())
+		return allowance, errors.New("Error unmarshalling allowance " + owner + ":" + spender)
+	}
+	return allowance, nil
+}
+
+func putAllowance(stub shim.ChaincodeStubInterface, allowance Allowance) error {
+	allowanceBytes, err := json.Marshal(&allowance)
+	if err != nil {
+		fmt.Println("Error marshalling allowance " + allowance.Owner + ":" + allowance.Spender)
+		return errors.New("Error marshalling allowance " + allowance.Owner + ":" + allowance.Spender)
+	}
+	err = stub.PutState(allowanceKey(allowance.Owner, allowance.Spender), allowanceBytes)
+	if err != nil {
+		fmt.Println("Error writing allowance " + allowance.Owner + ":" + allowance.Spender)
+		return errors.New("Error writing allowance " + allowance.Owner + ":" + allowance.Spender)
+	}
+	return nil
+}
+
+//===========================start============allowance functions=================================================
+// approve lets a spender pay up to amount out of the owner's account before expiry,
+// replacing any allowance the spender already had. The owner has to be the caller.
+// args: owner, spender, amount, expiry (ms)
+func (t *SimpleChaincode) approve(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Approving allowance.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting owner, spender, amount and expiry")
+	}
+
+	if args[0] == args[1] {
+		return nil, errors.New("Cannot approve " + args[0] + " to spend its own account")
+	}
+	err := checkCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = GetCompany(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	expiry, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid expiry " + args[3])
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if expiry <= now {
+		return nil, errors.New("Expiry " + args[3] + " is already past")
+	}
+
+	allowance := Allowance{Owner: args[0], Spender: args[1], Amount: amount, Remaining: amount, Expiry: expiry, GrantedAt: now}
+	err = putAllowance(stub, allowance)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== " + args[1] + " may spend " + args[2] + " of " + args[0] + " ====***====================")
+	return nil, nil
+}
+
+// transferFrom pays out of the owner's account on the spender's allowance. The spender names
+// itself after the transfer details and has to be the caller.
+// args: owner, to, amount, spender
+func (t *SimpleChaincode) transferFrom(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Transferring on allowance.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting owner, to, amount and spender")
+	}
+
+	err := checkCaller(stub, args[3])
+	if err != nil {
+		return nil, err
+	}
+	allowance, err := getAllowance(stub, args[0], args[3])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if allowance.Expiry <= now {
+		fmt.Println("===================Allowance from " + args[0] + " to " + args[3] + " has expired")
+		return nil, errors.New("Allowance from " + args[0] + " to " + args[3] + " has expired")
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	if amount > allowance.Remaining+0.005 {
+		fmt.Println("===================Allowance from " + args[0] + " to " + args[3] + " exceeded")
+		return nil, errors.New("Only " + formatAmount(allowance.Remaining) + " of the allowance from " + args[0] + " to " + args[3] + " is left")
+	}
+
+	transferID, err := transferFunds(stub, args[0], args[1], amount, "Allowance of "+args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	allowance.Remaining -= amount
+	if allowance.Remaining < 0.005 {
+		allowance.Remaining = 0
+	}
+	err = putAllowance(stub, allowance)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== " + args[3] + " spent " + args[2] + " of " + args[0] + " ====***====================")
+	return []byte(transferID), nil
+}
+
+// revokeAllowance removes a spender's allowance. The owner has to be the caller.
+// args: owner, spender
+func (t *SimpleChaincode) revokeAllowance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Revoking allowance.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting owner and spender")
+	}
+
+	err := checkCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = getAllowance(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DelState(allowanceKey(args[0], args[1]))
+	if err != nil {
+		return nil, errors.New("Error removing allowance " + args[0] + ":" + args[1])
+	}
+	return nil, nil
+}
+
+//===========================end============allowance functions=================================================
+
+//===========================start============allowance queries=================================================
+// getAllowances lists the allowances an account has granted or been granted
+// args: account, as of (ms, used to mark expired allowances)
+func getAllowances(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account and as of time")
+	}
+
+	asOf, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid as of time " + args[1])
+	}
+
+	allowances := []Allowance{}
+	err = scanPrefix(stub, allowancePrefix, func(key string, value []byte) error {
+		var allowance Allowance
+		err := json.Unmarshal(value, &allowance)
+		if err != nil {
+			return errors.New("Error unmarshalling allowance " + key)
+		}
+		if allowance.Owner == args[0] || allowance.Spender == args[0] {
+			allowance.Expired = allowance.Expiry <= asOf
+			allowances = append(allowances, allowance)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(allowances)
+}
+
+//===========================end============allowance queries=================================================
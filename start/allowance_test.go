@@ -0,0 +1,50 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestTransferFromWithinAllowance(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("buyer", "CORPORATE", "0")
+	s.openAccount("caterer", "VENDOR", "0")
+	s.mustInvoke("acme", "approve", "acme", "buyer", "30", "9000000")
+
+	s.mustInvoke("buyer", "transferFrom", "acme", "caterer", "20", "buyer")
+	if s.balance("caterer") != 20 || s.balance("acme") != 80 || s.balance("buyer") != 0 {
+		t.Errorf("buyer spent %v of acme's allowance, want 20", s.balance("caterer"))
+	}
+}
+
+func TestTransferFromRefusesOverspendAndImpostors(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("buyer", "CORPORATE", "0")
+	s.openAccount("caterer", "VENDOR", "0")
+	s.mustFail("buyer", "approve", "acme", "buyer", "30", "9000000")
+	s.mustInvoke("acme", "approve", "acme", "buyer", "30", "9000000")
+
+	s.mustFail("buyer", "transferFrom", "acme", "caterer", "31", "buyer")
+	s.mustFail("caterer", "transferFrom", "acme", "caterer", "10", "buyer")
+	s.mustInvoke("acme", "revokeAllowance", "acme", "buyer")
+	s.mustFail("buyer", "transferFrom", "acme", "caterer", "10", "buyer")
+	if s.balance("caterer") != 0 || s.balance("acme") != 100 {
+		t.Errorf("caterer received %v outside the allowance", s.balance("caterer"))
+	}
+}
@@ -0,0 +1,485 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========aml records===============
+var amlRulesPrefix = "cfg:aml:"
+var amlRecentPrefix = "amlrecent:"
+var amlFlagPrefix = "amlflag:"
+var amlPassPrefix = "amlpass:"
+var complianceOfficerPrefix = "complianceofficer:"
+
+const (
+	flagOpen     = "OPEN"
+	flagCleared  = "CLEARED"
+	flagRejected = "REJECTED"
+)
+
+// AmlRules flag transactions in one currency. A transfer above LargeAmount is flagged on its
+// own; a transfer that takes the payer's total over StructuringAmount within StructuringWindow
+// milliseconds is flagged as structuring. Structuring is only set on the base currency's rules
+// and totals transfers in every currency at their base value. Zero turns a rule off. With Hold
+// set, flagged transfers don't go ahead until a compliance officer clears them.
+type AmlRules struct {
+	LargeAmount       float64 `json:"largeAmount"`
+	StructuringAmount float64 `json:"structuringAmount"`
+	StructuringWindow int64   `json:"structuringWindow"`
+	Hold              bool    `json:"hold"`
+}
+
+// RecentTransfer is a transfer still inside a rolling window, such as the structuring window.
+// Amount is in Currency, or in the base currency when Currency is empty.
+type RecentTransfer struct {
+	Time     int64   `json:"time"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// AmlFlag is a transfer the rules flagged, made by Action. Result is the id of the transfer;
+// held transfers only get one once cleared, when the transfer alone is paid.
+type AmlFlag struct {
+	ID        string   `json:"id"`
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Amount    float64  `json:"amount"`
+	Currency  string   `json:"currency"`
+	Memo      string   `json:"memo,omitempty"`
+	Reasons   []string `json:"reasons"`
+	Held      bool     `json:"held"`
+	Action    string   `json:"action,omitempty"`
+	Status    string   `json:"status"`
+	Result    string   `json:"result,omitempty"`
+	CreatedAt int64    `json:"createdAt"`
+	ClosedBy  string   `json:"closedBy,omitempty"`
+	ClosedAt  int64    `json:"closedAt,omitempty"`
+	Note      string   `json:"note,omitempty"`
+}
+
+//============end==========aml records===============
+
+func getAmlRules(stub shim.ChaincodeStubInterface, currency string) (*AmlRules, error) {
+	rulesBytes, err := stub.GetState(amlRulesPrefix + currency)
+	if err != nil {
+		return nil, errors.New("Error reading aml rules for " + currency)
+	}
+	if len(rulesBytes) == 0 {
+		return nil, nil
+	}
+	var rules AmlRules
+	err = json.Unmarshal(rulesBytes, &rules)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling aml rules for " + currency)
+	}
+	return &rules, nil
+}
+
+func getAmlFlag(stub shim.ChaincodeStubInterface, flagID string) (AmlFlag, error) {
+	var flag AmlFlag
+	flagBytes, err := stub.GetState(amlFlagPrefix + flagID)
+	if err != nil || len(flagBytes) == 0 {
+		fmt.Println("Flag not found " + flagID)
+		return flag, errors.New("Flag not found " + flagID)
+	}
+
+	err = json.Unmarshal(flagBytes, &flag)
+	if err != nil {
+		fmt.Println("Error unmarshalling flag " + flagID + "\n err:" + err.Error())
+		return flag, errors.New("Error unmarshalling flag " + flagID)
+	}
+	return flag, nil
+}
+
+func putAmlFlag(stub shim.ChaincodeStubInterface, flag AmlFlag) error {
+	flagBytes, err := json.Marshal(&flag)
+	if err != nil {
+		fmt.Println("Error marshalling flag " + flag.ID)
+		return errors.New("Error marshalling flag " + flag.ID)
+	}
+	err = stub.PutState(amlFlagPrefix+flag.ID, flagBytes)
+	if err != nil {
+		fmt.Println("Error writing flag " + flag.ID)
+		return errors.New("Error writing flag " + flag.ID)
+	}
+	return nil
+}
+
+// amlHold stops a transfer the rules flagged for holding until a compliance officer clears it
+type amlHold struct {
+	flag AmlFlag
+}
+
+func (h *amlHold) Error() string {
+	return "Transfer from " + h.flag.From + " to " + h.flag.To + " is held for review"
+}
+
+func (h *amlHold) record(stub shim.ChaincodeStubInterface, action string) ([]byte, error) {
+	flag := h.flag
+	var err error
+	flag.ID, err = nextID(stub, "AML")
+	if err != nil {
+		return nil, err
+	}
+	flag.Action = action
+	fmt.Println("===================Transfer from " + flag.From + " to " + flag.To + " held as " + flag.ID)
+	return []byte(flag.ID), putAmlFlag(stub, flag)
+}
+
+func amlPassKey(fromID string, toID string, amount float64, currency string) string {
+	return amlPassPrefix + currency + ":" + fromID + ":" + toID + ":" + formatAmount(amount)
+}
+
+// checkAml applies the aml rules to a transfer about to be made. A held transfer stops with
+// an amlHold; any other flag is returned for the caller to record with the transfer's id.
+func checkAml(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, currency string, memo string) (*AmlFlag, error) {
+	flag, err := screenTransaction(stub, fromID, toID, amount, currency, memo)
+	if err != nil || flag == nil {
+		return nil, err
+	}
+	if flag.Held {
+		return nil, &amlHold{flag: *flag}
+	}
+	flag.ID, err = nextID(stub, "AML")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("===================Transfer from " + fromID + " to " + toID + " flagged as " + flag.ID)
+	return flag, nil
+}
+
+// screenTransaction applies the aml rules of a currency, and the base currency's structuring
+// rule, to a transfer, keeping the payer's recent transfers up to date. It returns the flag it
+// raised, without an id, or nil. A transfer a compliance officer just cleared isn't flagged again.
+func screenTransaction(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, currency string, memo string) (*AmlFlag, error) {
+	if amount <= 0 {
+		return nil, nil
+	}
+	rules, err := getAmlRules(stub, currency)
+	if err != nil {
+		return nil, err
+	}
+	baseRules := rules
+	if currency != baseCurrency {
+		baseRules, err = getAmlRules(stub, baseCurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rules == nil && baseRules == nil {
+		return nil, nil
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := []string{}
+	held := false
+	if rules != nil && rules.LargeAmount > 0 && amount > rules.LargeAmount {
+		reasons = append(reasons, "Amount "+formatAmount(amount)+" is above "+formatAmount(rules.LargeAmount))
+		held = rules.Hold
+	}
+
+	if baseRules != nil && baseRules.StructuringAmount > 0 && baseRules.StructuringWindow > 0 {
+		value, err := convertAmount(stub, amount, currency, baseCurrency)
+		if err != nil {
+			return nil, err
+		}
+		recentKey := amlRecentPrefix + fromID
+		var recent []RecentTransfer
+		recentBytes, err := stub.GetState(recentKey)
+		if err != nil {
+			return nil, errors.New("Error reading recent transfers of " + fromID)
+		}
+		if len(recentBytes) > 0 {
+			err = json.Unmarshal(recentBytes, &recent)
+			if err != nil {
+				return nil, errors.New("Error unmarshalling recent transfers of " + fromID)
+			}
+		}
+
+		kept := []RecentTransfer{}
+		total := value
+		for _, previous := range recent {
+			if previous.Time > now-baseRules.StructuringWindow {
+				kept = append(kept, previous)
+				total += previous.Amount
+			}
+		}
+		kept = append(kept, RecentTransfer{Time: now, Amount: value})
+		if total > baseRules.StructuringAmount && len(kept) > 1 {
+			reasons = append(reasons, strconv.Itoa(len(kept))+" transfers totalling "+formatAmount(total)+" "+baseCurrency+" within "+strconv.FormatInt(baseRules.StructuringWindow, 10)+"ms are above "+formatAmount(baseRules.StructuringAmount))
+			held = held || baseRules.Hold
+		}
+
+		recentBytes, err = json.Marshal(kept)
+		if err != nil {
+			return nil, errors.New("Error marshalling recent transfers of " + fromID)
+		}
+		err = stub.PutState(recentKey, recentBytes)
+		if err != nil {
+			return nil, errors.New("Error writing recent transfers of " + fromID)
+		}
+	}
+
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+	passKey := amlPassKey(fromID, toID, amount, currency)
+	pass, err := stub.GetState(passKey)
+	if err != nil {
+		return nil, errors.New("Error reading aml clearance of " + fromID)
+	}
+	if len(pass) > 0 {
+		err = stub.DelState(passKey)
+		if err != nil {
+			return nil, errors.New("Error deleting aml clearance of " + fromID)
+		}
+		fmt.Println("===================Transfer from " + fromID + " to " + toID + " cleared by flag " + string(pass))
+		return nil, nil
+	}
+	return &AmlFlag{From: fromID, To: toID, Amount: amount, Currency: currency, Memo: memo, Reasons: reasons, Held: held, Status: flagOpen, CreatedAt: now}, nil
+}
+
+// canReviewFlags checks an account is an admin or a designated compliance officer
+func canReviewFlags(stub shim.ChaincodeStubInterface, accountID string) (bool, error) {
+	admin, err := isAdmin(stub, accountID)
+	if err != nil || admin {
+		return admin, err
+	}
+	officerBytes, err := stub.GetState(complianceOfficerPrefix + accountID)
+	if err != nil {
+		return false, errors.New("Error reading compliance officer role of " + accountID)
+	}
+	return len(officerBytes) > 0, nil
+}
+
+// reviewFlag loads an open flag and checks officer may close it
+func reviewFlag(stub shim.ChaincodeStubInterface, officer string, flagID string, note string) (AmlFlag, error) {
+	allowed, err := canReviewFlags(stub, officer)
+	if err != nil {
+		return AmlFlag{}, err
+	}
+	if !allowed {
+		return AmlFlag{}, errors.New("Invalid Reuest to review flags for " + officer)
+	}
+	flag, err := getAmlFlag(stub, flagID)
+	if err != nil {
+		return flag, err
+	}
+	if flag.Status != flagOpen {
+		return flag, errors.New("Flag " + flag.ID + " is " + flag.Status)
+	}
+	if officer == flag.From || officer == flag.To {
+		return flag, errors.New("Flag " + flag.ID + " can't be reviewed by a party to it")
+	}
+
+	flag.ClosedAt, err = txTimestampMs(stub)
+	if err != nil {
+		return flag, err
+	}
+	flag.ClosedBy = officer
+	flag.Note = note
+	return flag, nil
+}
+
+//===========================start============aml functions=================================================
+// setAmlRules sets the aml rules for a currency
+// args: admin, currency, large amount, structuring amount, structuring window (ms), hold (true|false)
+func (t *SimpleChaincode) setAmlRules(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting aml rules.=========================")
+
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, currency, large amount, structuring amount, structuring window and hold")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set aml rules for " + args[0])
+	}
+	currency, err := currencyArg(stub, args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules AmlRules
+	rules.LargeAmount, err = strconv.ParseFloat(args[2], 64)
+	if err != nil || rules.LargeAmount < 0 {
+		return nil, errors.New("Invalid large amount " + args[2])
+	}
+	rules.StructuringAmount, err = strconv.ParseFloat(args[3], 64)
+	if err != nil || rules.StructuringAmount < 0 {
+		return nil, errors.New("Invalid structuring amount " + args[3])
+	}
+	rules.StructuringWindow, err = strconv.ParseInt(args[4], 10, 64)
+	if err != nil || rules.StructuringWindow < 0 {
+		return nil, errors.New("Invalid structuring window " + args[4])
+	}
+	// Structuring totals every currency at its base value, so it has one rule
+	if currency != baseCurrency && (rules.StructuringAmount > 0 || rules.StructuringWindow > 0) {
+		return nil, errors.New("Structuring is set on the " + baseCurrency + " rules, not " + currency)
+	}
+	rules.Hold, err = strconv.ParseBool(args[5])
+	if err != nil {
+		return nil, errors.New("Expecting true or false, not " + args[5])
+	}
+
+	rulesBytes, err := json.Marshal(&rules)
+	if err != nil {
+		return nil, errors.New("Error marshalling aml rules for " + currency)
+	}
+	err = stub.PutState(amlRulesPrefix+currency, rulesBytes)
+	if err != nil {
+		return nil, errors.New("Error writing aml rules for " + currency)
+	}
+	return nil, nil
+}
+
+// setComplianceOfficer gives an account the role of reviewing aml flags, or takes it away
+// args: admin, account, true|false
+func (t *SimpleChaincode) setComplianceOfficer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting compliance officer.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, account and true or false")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set compliance officer for " + args[0])
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if args[2] == "true" {
+		err = stub.PutState(complianceOfficerPrefix+args[1], []byte(args[0]))
+	} else if args[2] == "false" {
+		err = stub.DelState(complianceOfficerPrefix + args[1])
+	} else {
+		return nil, errors.New("Expecting true or false, not " + args[2])
+	}
+	if err != nil {
+		return nil, errors.New("Error writing compliance officer role of " + args[1])
+	}
+	return nil, nil
+}
+
+// clearFlag closes a flag as reviewed. A held transfer is paid as it was first made, without
+// being flagged again; the rest of the action it was part of isn't carried out.
+// args: officer, flag id[, note]
+func (t *SimpleChaincode) clearFlag(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Clearing flag.=========================")
+
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting officer, flag id and optional note")
+	}
+
+	note := ""
+	if len(args) == 3 {
+		note = args[2]
+	}
+	flag, err := reviewFlag(stub, args[0], args[1], note)
+	if err != nil {
+		return nil, err
+	}
+	if flag.Held {
+		passKey := amlPassKey(flag.From, flag.To, flag.Amount, flag.Currency)
+		err = stub.PutState(passKey, []byte(flag.ID))
+		if err != nil {
+			return nil, errors.New("Error writing aml clearance of " + flag.From)
+		}
+		flag.Result, err = executeTransfer(stub, flag.From, flag.To, flag.Amount, flag.Currency, flag.Memo)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.DelState(passKey)
+		if err != nil {
+			return nil, errors.New("Error deleting aml clearance of " + flag.From)
+		}
+	}
+	flag.Status = flagCleared
+	err = putAmlFlag(stub, flag)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Flag " + flag.ID + " cleared by " + args[0] + " ====***====================")
+	return []byte(flag.Result), nil
+}
+
+// rejectFlag closes a flag as suspicious. A held transaction is released without paying.
+// args: officer, flag id, reason
+func (t *SimpleChaincode) rejectFlag(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Rejecting flag.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting officer, flag id and reason")
+	}
+
+	flag, err := reviewFlag(stub, args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	flag.Status = flagRejected
+	return nil, putAmlFlag(stub, flag)
+}
+
+//===========================end============aml functions=================================================
+
+//===========================start============aml queries=================================================
+// getOpenFlags lists the flags waiting for a compliance officer, optionally only one account's
+// args: [account]
+func getOpenFlags(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting optional account")
+	}
+
+	flags := []AmlFlag{}
+	err := scanPrefix(stub, amlFlagPrefix, func(key string, value []byte) error {
+		var flag AmlFlag
+		err := json.Unmarshal(value, &flag)
+		if err != nil {
+			return errors.New("Error unmarshalling flag " + key)
+		}
+		if flag.Status != flagOpen {
+			return nil
+		}
+		if len(args) == 1 && flag.From != args[0] && flag.To != args[0] {
+			return nil
+		}
+		flags = append(flags, flag)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(flags)
+}
+
+//===========================end============aml queries=================================================
@@ -0,0 +1,64 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClearedFlagReleasesHeldTransfer(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "1000")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("officer", "CORPORATE", "0")
+	s.mustInvoke("root", "setComplianceOfficer", "root", "officer", "true")
+	s.mustInvoke("root", "setAmlRules", "root", "USD", "100", "0", "0", "true")
+
+	id := string(s.mustInvoke("acme", "transaction", "acme", "relief", "300", "large gift"))
+	if !strings.HasPrefix(id, "AML") || s.balance("relief") != 0 {
+		t.Fatalf("large gift returned %s and paid %v before review", id, s.balance("relief"))
+	}
+	s.mustInvoke("officer", "clearFlag", "officer", id, "known donor")
+	if s.balance("relief") != 300 || s.balance("acme") != 700 {
+		t.Errorf("cleared gift paid relief %v, want 300", s.balance("relief"))
+	}
+}
+
+func TestRejectedFlagRefusesHeldTransfer(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "1000")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("officer", "CORPORATE", "0")
+	s.mustInvoke("root", "setAmlRules", "root", "USD", "100", "150", "600000", "true")
+
+	id := string(s.mustInvoke("acme", "transaction", "acme", "relief", "300", "large gift"))
+	s.mustFail("officer", "clearFlag", "officer", id)
+	s.mustInvoke("root", "setComplianceOfficer", "root", "officer", "true")
+	s.mustInvoke("officer", "rejectFlag", "officer", id, "unknown source")
+	s.mustFail("officer", "clearFlag", "officer", id)
+
+	s.mustInvoke("acme", "transaction", "acme", "relief", "80", "part one")
+	split := string(s.mustInvoke("acme", "transaction", "acme", "relief", "80", "part two"))
+	if !strings.HasPrefix(split, "AML") {
+		t.Errorf("second of two 80 gifts returned %s, want a structuring flag", split)
+	}
+	if s.balance("relief") != 80 || s.balance("acme") != 920 {
+		t.Errorf("held gifts paid relief %v, want only the first 80", s.balance("relief"))
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========authorization records===============
+var authorizationPrefix = "auth:"
+
+const (
+	authorizationOpen     = "AUTHORIZED"
+	authorizationCaptured = "CAPTURED"
+	authorizationVoided   = "VOIDED"
+	authorizationExpired  = "EXPIRED"
+)
+
+// Authorization reserves part of a payer's available balance for a vendor, who can later
+// capture up to the authorized amount. The rest of the hold is released on capture.
+type Authorization struct {
+	ID        string  `json:"id"`
+	Payer     string  `json:"payer"`
+	Vendor    string  `json:"vendor"`
+	Amount    float64 `json:"amount"`
+	Captured  float64 `json:"captured"`
+	Status    string  `json:"status"`
+	CreatedAt int64   `json:"createdAt"`
+	ExpiresAt int64   `json:"expiresAt"`
+	ClosedAt  int64   `json:"closedAt,omitempty"`
+}
+
+//============end==========authorization records===============
+
+func getAuthorization(stub shim.ChaincodeStubInterface, authID string) (Authorization, error) {
+	var auth Authorization
+	authBytes, err := stub.GetState(authorizationPrefix + authID)
+	if err != nil || len(authBytes) == 0 {
+		fmt.Println("Authorization not found " + authID)
+		return auth, errors.New("Authorization not found " + authID)
+	}
+
+	err = json.Unmarshal(authBytes, &auth)
+	if err != nil {
+		fmt.Println("Error unmarshalling authorization " + authID + "\n err:" + err.Error())
+		return auth, errors.New("Error unmarshalling authorization " + authID)
+	}
+	return auth, nil
+}
+
+func putAuthorization(stub shim.ChaincodeStubInterface, auth Authorization) error {
+	authBytes, err := json.Marshal(&auth)
+	if err != nil {
+		fmt.Println("Error marshalling authorization " + auth.ID)
+		return errors.New("Error marshalling authorization " + auth.ID)
+	}
+	err = stub.PutState(authorizationPrefix+auth.ID, authBytes)
+	if err != nil {
+		fmt.Println("Error writing authorization " + auth.ID)
+		return errors.New("Error writing authorization " + auth.ID)
+	}
+	return nil
+}
+
+// closeAuthorization releases the authorization's hold and stamps its final status
+func closeAuthorization(stub shim.ChaincodeStubInterface, auth Authorization, status string, now int64) error {
+	err := releaseHold(stub, auth.Payer, auth.Amount)
+	if err != nil {
+		return err
+	}
+	auth.Status = status
+	auth.ClosedAt = now
+	return putAuthorization(stub, auth)
+}
+
+//===========================start============authorization functions=================================================
+// authorize holds an amount on the payer's account for a vendor to capture later
+// args: payer, vendor, amount, ttl (ms)
+func (t *SimpleChaincode) authorize(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Authorizing payment.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting payer, vendor, amount and ttl")
+	}
+
+	_, err := getAccountOfType(stub, args[1], "VENDOR")
+	if err != nil {
+		return nil, err
+	}
+	if args[0] == args[1] {
+		return nil, errors.New("Cannot authorize a payment from " + args[0] + " to itself")
+	}
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	ttl, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || ttl <= 0 {
+		return nil, errors.New("Invalid ttl " + args[3])
+	}
+
+	// Multisig accounts can't hold funds for a payment their signers haven't approved
+	err = checkSingleSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse up front what capture would refuse, rather than holding funds for it
+	err = checkParties(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkPayee(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkSpendingLimits(stub, args[0], amount, baseCurrency)
+	if err != nil {
+		return nil, err
+	}
+	err = placeHold(stub, args[0], amount)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	authID, err := nextID(stub, "AUT")
+	if err != nil {
+		return nil, err
+	}
+	auth := Authorization{ID: authID, Payer: args[0], Vendor: args[1], Amount: amount, Status: authorizationOpen, CreatedAt: now, ExpiresAt: now + ttl}
+	err = putAuthorization(stub, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Authorization " + authID + " placed ====***====================")
+	return []byte(authID), nil
+}
+
+// capture pays the vendor up to the authorized amount and releases the rest of the hold
+// args: vendor, authorization id, amount
+func (t *SimpleChaincode) capture(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Capturing payment.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting vendor, authorization id and amount")
+	}
+
+	auth, err := getAuthorization(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if auth.Vendor != args[0] {
+		return nil, errors.New("Authorization " + auth.ID + " was not made to " + args[0])
+	}
+	if auth.Status != authorizationOpen {
+		return nil, errors.New("Authorization " + auth.ID + " is " + auth.Status)
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now >= auth.ExpiresAt {
+		return nil, errors.New("Authorization " + auth.ID + " has expired")
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	if amount > auth.Amount+0.005 {
+		return nil, errors.New("Capture of " + args[2] + " is more than the " + strconv.FormatFloat(auth.Amount, 'f', 2, 64) + " authorized")
+	}
+
+	auth.Captured = amount
+	err = closeAuthorization(stub, auth, authorizationCaptured, now)
+	if err != nil {
+		return nil, err
+	}
+	_, err = transferFunds(stub, auth.Payer, auth.Vendor, amount, "Authorization "+auth.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Authorization " + auth.ID + " captured ====***====================")
+	return nil, nil
+}
+
+// voidAuthorization releases an authorization without paying anything. Either side may void it.
+// args: payer or vendor, authorization id
+func (t *SimpleChaincode) voidAuthorization(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Voiding authorization.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting payer or vendor and authorization id")
+	}
+
+	auth, err := getAuthorization(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if auth.Payer != args[0] && auth.Vendor != args[0] {
+		return nil, errors.New("Authorization " + auth.ID + " does not involve " + args[0])
+	}
+	if auth.Status != authorizationOpen {
+		return nil, errors.New("Authorization " + auth.ID + " is " + auth.Status)
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, closeAuthorization(stub, auth, authorizationVoided, now)
+}
+
+// expireAuthorizations releases the holds of every authorization past its expiry. Anyone may call it.
+func (t *SimpleChaincode) expireAuthorizations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Expiring authorizations.=========================")
+
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting none")
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []Authorization
+	err = scanPrefix(stub, authorizationPrefix, func(key string, value []byte) error {
+		var auth Authorization
+		err := json.Unmarshal(value, &auth)
+		if err != nil {
+			return errors.New("Error unmarshalling authorization " + key)
+		}
+		if auth.Status == authorizationOpen && now >= auth.ExpiresAt {
+			stale = append(stale, auth)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []string{}
+	for _, auth := range stale {
+		err = closeAuthorization(stub, auth, authorizationExpired, now)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, auth.ID)
+	}
+
+	fmt.Println("==================***=== Expired " + strconv.Itoa(len(expired)) + " authorizations ====***====================")
+	return json.Marshal(expired)
+}
+
+//===========================end============authorization functions=================================================
+
+//===========================start============authorization queries=================================================
+// getAuthorizations lists the open authorizations an account has placed or received
+// args: account
+func getAuthorizations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	auths := []Authorization{}
+	err := scanPrefix(stub, authorizationPrefix, func(key string, value []byte) error {
+		var auth Authorization
+		err := json.Unmarshal(value, &auth)
+		if err != nil {
+			return errors.New("Error unmarshalling authorization " + key)
+		}
+		if auth.Status == authorizationOpen && (auth.Payer == args[0] || auth.Vendor == args[0]) {
+			auths = append(auths, auth)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(auths)
+}
+
+//===========================end============authorization queries=================================================
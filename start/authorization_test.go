@@ -0,0 +1,50 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestCaptureAuthorizedHold(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("caterer", "VENDOR", "0")
+	id := string(s.mustInvoke("acme", "authorize", "acme", "caterer", "80", "600000"))
+	s.mustFail("acme", "transaction", "acme", "caterer", "30", "spend the hold")
+
+	s.mustInvoke("caterer", "capture", "caterer", id, "50")
+	s.mustInvoke("acme", "transaction", "acme", "caterer", "50", "lunch")
+	if s.balance("caterer") != 100 || s.balance("acme") != 0 {
+		t.Errorf("capture of 50 left caterer with %v, want 100 after the follow-up payment", s.balance("caterer"))
+	}
+}
+
+func TestCaptureRefusesOverAndExpiredHolds(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("caterer", "VENDOR", "0")
+	s.mustInvoke("root", "setSpendingLimit", "root", "ACCOUNT", "acme", "60", "0", "0")
+	s.mustFail("acme", "authorize", "acme", "caterer", "80", "600000")
+
+	id := string(s.mustInvoke("acme", "authorize", "acme", "caterer", "50", "60000"))
+	s.mustFail("caterer", "capture", "caterer", id, "55")
+	s.now += 60000
+	s.mustFail("caterer", "capture", "caterer", id, "50")
+	if s.balance("caterer") != 0 || s.balance("acme") != 100 {
+		t.Errorf("caterer captured %v from a refused hold", s.balance("caterer"))
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// stateBuffer holds back the writes of part of a transaction so they can be kept or dropped
+// together. Reads see the buffered writes; everything else goes to the wrapped stub.
+type stateBuffer struct {
+	shim.ChaincodeStubInterface
+	writes  map[string][]byte
+	deleted map[string]bool
+}
+
+func newStateBuffer(stub shim.ChaincodeStubInterface) *stateBuffer {
+	return &stateBuffer{ChaincodeStubInterface: stub, writes: map[string][]byte{}, deleted: map[string]bool{}}
+}
+
+func (b *stateBuffer) GetState(key string) ([]byte, error) {
+	if b.deleted[key] {
+		return nil, nil
+	}
+	if value, ok := b.writes[key]; ok {
+		return value, nil
+	}
+	return b.ChaincodeStubInterface.GetState(key)
+}
+
+func (b *stateBuffer) PutState(key string, value []byte) error {
+	b.writes[key] = value
+	delete(b.deleted, key)
+	return nil
+}
+
+func (b *stateBuffer) DelState(key string) error {
+	delete(b.writes, key)
+	b.deleted[key] = true
+	return nil
+}
+
+// RangeQueryState merges the buffered writes into the wrapped stub's range
+func (b *stateBuffer) RangeQueryState(startKey string, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	iter, err := b.ChaincodeStubInterface.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	values := map[string][]byte{}
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !b.deleted[key] {
+			values[key] = value
+		}
+	}
+	for key, value := range b.writes {
+		if key >= startKey && key < endKey {
+			values[key] = value
+		}
+	}
+
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return &bufferedRange{keys: keys, values: values}, nil
+}
+
+// commit writes the buffered changes through to the wrapped stub, in key order so every
+// peer writes them the same way
+func (b *stateBuffer) commit() error {
+	keys := []string{}
+	for key := range b.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := b.ChaincodeStubInterface.PutState(key, b.writes[key])
+		if err != nil {
+			return errors.New("Error writing " + key)
+		}
+	}
+
+	keys = []string{}
+	for key := range b.deleted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := b.ChaincodeStubInterface.DelState(key)
+		if err != nil {
+			return errors.New("Error deleting " + key)
+		}
+	}
+	return nil
+}
+
+// bufferedRange iterates over a range already read into memory
+type bufferedRange struct {
+	keys   []string
+	values map[string][]byte
+	next   int
+}
+
+func (r *bufferedRange) HasNext() bool {
+	return r.next < len(r.keys)
+}
+
+func (r *bufferedRange) Next() (string, []byte, error) {
+	if r.next >= len(r.keys) {
+		return "", nil, errors.New("No more keys in range")
+	}
+	key := r.keys[r.next]
+	r.next++
+	return key, r.values[key], nil
+}
+
+func (r *bufferedRange) Close() error {
+	return nil
+}
@@ -33,16 +33,53 @@ import (
 type SimpleChaincode struct {
 }
 
+// Account balances: CashBalance is the ledger balance in the base currency, HeldBalance the
+// part of it reserved by holds and authorizations, and AvailableBalance what is left to spend.
+// Balances lists the balance in every currency the account holds, the base currency included.
+// Profile is kept under its own key and only filled in for the GetCompany query.
 type Account struct {
-	ID          string  `json:"id"`
-	Prefix      string  `json:"prefix"`
-	CashBalance float64 `json:"cashBalance"`
+	ID               string             `json:"id"`
+	Prefix           string             `json:"prefix"`
+	CashBalance      float64            `json:"cashBalance"`
+	HeldBalance      float64            `json:"heldBalance"`
+	AvailableBalance float64            `json:"availableBalance"`
+	Balances         map[string]float64 `json:"balances,omitempty"`
+	Profile          *Profile           `json:"profile,omitempty"`
+}
+
+// Transfer records one movement of money between two accounts, in the base currency unless
+// Currency says otherwise. Conversions credit the payee Credited in CreditCurrency at Rate.
+// A transfer that was charged a fee notes it, and the fee itself is a separate transfer to
+// the collector pointing back through FeeOf. Refunds point back at the transfer they refund
+// and the original keeps a running total of what was refunded. Matches paid by a corporate
+// program point back at the donation they match through MatchOf.
+type Transfer struct {
+	ID              string  `json:"id"`
+	From            string  `json:"from"`
+	To              string  `json:"to"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency,omitempty"`
+	Memo            string  `json:"memo,omitempty"`
+	Fee             float64 `json:"fee,omitempty"`
+	FeeCollector    string  `json:"feeCollector,omitempty"`
+	FeeOf           string  `json:"feeOf,omitempty"`
+	Rate            float64 `json:"rate,omitempty"`
+	RateEffectiveAt int64   `json:"rateEffectiveAt,omitempty"`
+	Credited        float64 `json:"credited,omitempty"`
+	CreditCurrency  string  `json:"creditCurrency,omitempty"`
+	RefundOf        string  `json:"refundOf,omitempty"`
+	MatchOf         string  `json:"matchOf,omitempty"`
+	Refunded        float64 `json:"refunded,omitempty"`
+	Time            int64   `json:"time"`
+	TxID            string  `json:"txId"`
 }
 
 //============start==========added globle var===============
 var cpPrefix = "cp:"
 var cpPrefixTest = "cptest:"
 var accountPrefix = "acct:"
+var seqPrefix = "seq:"
+var transferPrefix = "txfr:"
 
 //============end==========added globle var===============
 
@@ -80,6 +117,8 @@ func msToTime(ms string) (time.Time, error) {
 
 //===========end======added for account creation ================
 
+// Init sets up the admin set. Later admins can only be added by one of these.
+// args: admin usernames
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Printf("========================= Init called, initializing chaincode")
 
@@ -90,8 +129,22 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	var Aval, Bval int // Asset holdings
 	var err error*/
 
-	if len(args) != 4 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 4")
+	if len(args) == 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin usernames")
+	}
+
+	admins, err := getAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(admins) > 0 {
+		return nil, errors.New("Admins are already set up")
+	}
+	for _, admin := range args {
+		_, err = openAdminAccount(stub, admin)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Initialize the chaincode
@@ -198,7 +251,27 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 
 // Invoke callback representing the invocation of a chaincode
 // This chaincode will manage two accounts A and B and will transfer X units from A to B upon invoke
+// A function stopped by a compliance rule writes nothing but the record of the stop, which
+// is handed back instead of an error so it stays on the ledger.
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	buffer := newStateBuffer(stub)
+	result, err := t.invokeFunction(buffer, function, args)
+	if stop, ok := err.(complianceStop); ok {
+		fmt.Println("===================" + function + " stopped: " + stop.Error())
+		return stop.record(stub, actionName(function))
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = buffer.commit()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// invokeFunction runs the invoke function named by function
+func (t *SimpleChaincode) invokeFunction(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Printf("=========================Invoke called, determining function==========val = = " + function)
 
 	// Handle different functions
@@ -223,6 +296,192 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
 	} else if function == "adminamtupdate" {
 		fmt.Printf("=========================Function is admin amount ===")
 		return t.adminamtupdate(stub, args)
+	} else if function == "submitInvoice" {
+		fmt.Printf("=========================Function is submitInvoice")
+		return t.submitInvoice(stub, args)
+	} else if function == "payInvoice" {
+		fmt.Printf("=========================Function is payInvoice")
+		return t.payInvoice(stub, args)
+	} else if function == "createPurchaseOrder" {
+		fmt.Printf("=========================Function is createPurchaseOrder")
+		return t.createPurchaseOrder(stub, args)
+	} else if function == "recordGoodsReceipt" {
+		fmt.Printf("=========================Function is recordGoodsReceipt")
+		return t.recordGoodsReceipt(stub, args)
+	} else if function == "setMatchTolerance" {
+		fmt.Printf("=========================Function is setMatchTolerance")
+		return t.setMatchTolerance(stub, args)
+	} else if function == "createTender" {
+		fmt.Printf("=========================Function is createTender")
+		return t.createTender(stub, args)
+	} else if function == "commitBid" {
+		fmt.Printf("=========================Function is commitBid")
+		return t.commitBid(stub, args)
+	} else if function == "revealBid" {
+		fmt.Printf("=========================Function is revealBid")
+		return t.revealBid(stub, args)
+	} else if function == "awardTender" {
+		fmt.Printf("=========================Function is awardTender")
+		return t.awardTender(stub, args)
+	} else if function == "settleTender" {
+		fmt.Printf("=========================Function is settleTender")
+		return t.settleTender(stub, args)
+	} else if function == "cancelTender" {
+		fmt.Printf("=========================Function is cancelTender")
+		return t.cancelTender(stub, args)
+	} else if function == "setVendorCategory" {
+		fmt.Printf("=========================Function is setVendorCategory")
+		return t.setVendorCategory(stub, args)
+	} else if function == "issueVoucher" {
+		fmt.Printf("=========================Function is issueVoucher")
+		return t.issueVoucher(stub, args)
+	} else if function == "redeemVoucher" {
+		fmt.Printf("=========================Function is redeemVoucher")
+		return t.redeemVoucher(stub, args)
+	} else if function == "sweepExpiredVouchers" {
+		fmt.Printf("=========================Function is sweepExpiredVouchers")
+		return t.sweepExpiredVouchers(stub, args)
+	} else if function == "createMatchingProgram" {
+		fmt.Printf("=========================Function is createMatchingProgram")
+		return t.createMatchingProgram(stub, args)
+	} else if function == "closeMatchingProgram" {
+		fmt.Printf("=========================Function is closeMatchingProgram")
+		return t.closeMatchingProgram(stub, args)
+	} else if function == "mint" {
+		fmt.Printf("=========================Function is mint")
+		return t.mint(stub, args)
+	} else if function == "burn" {
+		fmt.Printf("=========================Function is burn")
+		return t.burn(stub, args)
+	} else if function == "setMintQuorum" {
+		fmt.Printf("=========================Function is setMintQuorum")
+		return t.setMintQuorum(stub, args)
+	} else if function == "approveProposal" {
+		fmt.Printf("=========================Function is approveProposal")
+		return t.approveProposal(stub, args)
+	} else if function == "cancelProposal" {
+		fmt.Printf("=========================Function is cancelProposal")
+		return t.cancelProposal(stub, args)
+	} else if function == "setTransferApprovers" {
+		fmt.Printf("=========================Function is setTransferApprovers")
+		return t.setTransferApprovers(stub, args)
+	} else if function == "requestTransfer" {
+		fmt.Printf("=========================Function is requestTransfer")
+		return t.requestTransfer(stub, args)
+	} else if function == "approveTransfer" {
+		fmt.Printf("=========================Function is approveTransfer")
+		return t.approveTransfer(stub, args)
+	} else if function == "rejectTransfer" {
+		fmt.Printf("=========================Function is rejectTransfer")
+		return t.rejectTransfer(stub, args)
+	} else if function == "authorize" {
+		fmt.Printf("=========================Function is authorize")
+		return t.authorize(stub, args)
+	} else if function == "capture" {
+		fmt.Printf("=========================Function is capture")
+		return t.capture(stub, args)
+	} else if function == "voidAuthorization" {
+		fmt.Printf("=========================Function is voidAuthorization")
+		return t.voidAuthorization(stub, args)
+	} else if function == "expireAuthorizations" {
+		fmt.Printf("=========================Function is expireAuthorizations")
+		return t.expireAuthorizations(stub, args)
+	} else if function == "refund" {
+		fmt.Printf("=========================Function is refund")
+		return t.refund(stub, args)
+	} else if function == "createSchedule" {
+		fmt.Printf("=========================Function is createSchedule")
+		return t.createSchedule(stub, args)
+	} else if function == "cancelSchedule" {
+		fmt.Printf("=========================Function is cancelSchedule")
+		return t.cancelSchedule(stub, args)
+	} else if function == "processDue" {
+		fmt.Printf("=========================Function is processDue")
+		return t.processDue(stub, args)
+	} else if function == "setSpendingLimit" {
+		fmt.Printf("=========================Function is setSpendingLimit")
+		return t.setSpendingLimit(stub, args)
+	} else if function == "grantLimitOverride" {
+		fmt.Printf("=========================Function is grantLimitOverride")
+		return t.grantLimitOverride(stub, args)
+	} else if function == "setFeeCollector" {
+		fmt.Printf("=========================Function is setFeeCollector")
+		return t.setFeeCollector(stub, args)
+	} else if function == "setFeeRule" {
+		fmt.Printf("=========================Function is setFeeRule")
+		return t.setFeeRule(stub, args)
+	} else if function == "registerCurrency" {
+		fmt.Printf("=========================Function is registerCurrency")
+		return t.registerCurrency(stub, args)
+	} else if function == "publishFxRate" {
+		fmt.Printf("=========================Function is publishFxRate")
+		return t.publishFxRate(stub, args)
+	} else if function == "setFxMaxAge" {
+		fmt.Printf("=========================Function is setFxMaxAge")
+		return t.setFxMaxAge(stub, args)
+	} else if function == "convertAndTransfer" {
+		fmt.Printf("=========================Function is convertAndTransfer")
+		return t.convertAndTransfer(stub, args)
+	} else if function == "approve" {
+		fmt.Printf("=========================Function is approve")
+		return t.approve(stub, args)
+	} else if function == "transferFrom" {
+		fmt.Printf("=========================Function is transferFrom")
+		return t.transferFrom(stub, args)
+	} else if function == "revokeAllowance" {
+		fmt.Printf("=========================Function is revokeAllowance")
+		return t.revokeAllowance(stub, args)
+	} else if function == "setMultisig" {
+		fmt.Printf("=========================Function is setMultisig")
+		return t.setMultisig(stub, args)
+	} else if function == "approveSpend" {
+		fmt.Printf("=========================Function is approveSpend")
+		return t.approveSpend(stub, args)
+	} else if function == "cancelSpend" {
+		fmt.Printf("=========================Function is cancelSpend")
+		return t.cancelSpend(stub, args)
+	} else if function == "setNgoRegistration" {
+		fmt.Printf("=========================Function is setNgoRegistration")
+		return t.setNgoRegistration(stub, args)
+	} else if function == "updateProfile" {
+		fmt.Printf("=========================Function is updateProfile")
+		return t.updateProfile(stub, args)
+	} else if function == "setVerifier" {
+		fmt.Printf("=========================Function is setVerifier")
+		return t.setVerifier(stub, args)
+	} else if function == "setVerificationRequired" {
+		fmt.Printf("=========================Function is setVerificationRequired")
+		return t.setVerificationRequired(stub, args)
+	} else if function == "setVerificationStatus" {
+		fmt.Printf("=========================Function is setVerificationStatus")
+		return t.setVerificationStatus(stub, args)
+	} else if function == "addToBlocklist" {
+		fmt.Printf("=========================Function is addToBlocklist")
+		return t.addToBlocklist(stub, args)
+	} else if function == "removeFromBlocklist" {
+		fmt.Printf("=========================Function is removeFromBlocklist")
+		return t.removeFromBlocklist(stub, args)
+	} else if function == "setAmlRules" {
+		fmt.Printf("=========================Function is setAmlRules")
+		return t.setAmlRules(stub, args)
+	} else if function == "setComplianceOfficer" {
+		fmt.Printf("=========================Function is setComplianceOfficer")
+		return t.setComplianceOfficer(stub, args)
+	} else if function == "clearFlag" {
+		fmt.Printf("=========================Function is clearFlag")
+		return t.clearFlag(stub, args)
+	} else if function == "rejectFlag" {
+		fmt.Printf("=========================Function is rejectFlag")
+		return t.rejectFlag(stub, args)
+	} else if function == "requestPayeeChange" {
+		fmt.Printf("=========================Function is requestPayeeChange")
+		return t.requestPayeeChange(stub, args)
+	} else if function == "approvePayeeChange" {
+		fmt.Printf("=========================Function is approvePayeeChange")
+		return t.approvePayeeChange(stub, args)
+	} else if function == "cancelPayeeChange" {
+		fmt.Printf("=========================Function is cancelPayeeChange")
+		return t.cancelPayeeChange(stub, args)
 	}
 
 	return nil, errors.New("Received unknown function invocation")
@@ -319,6 +578,10 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
 			fmt.Println("Error from getCompany")
 			return nil, errors.New("User Does not exist")
 		} else {
+			company.Profile, err = getProfile(stub, company.ID)
+			if err != nil {
+				return nil, err
+			}
 			companyBytes, err1 := json.Marshal(&company)
 			if err1 != nil {
 				fmt.Println("Error marshalling the company")
@@ -327,6 +590,99 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
 			fmt.Println("All success, returning the company")
 			return companyBytes, nil
 		}
+	} else if function == "getInvoices" {
+		fmt.Println("Getting the invoices")
+		return getInvoices(stub, args)
+	} else if function == "getMatchReport" {
+		fmt.Println("Getting the three-way match report")
+		return getMatchReport(stub, args)
+	} else if function == "getTenderStatus" {
+		fmt.Println("Getting the tender status")
+		return getTenderStatus(stub, args)
+	} else if function == "getTenders" {
+		fmt.Println("Getting the tenders")
+		return getTenders(stub, args)
+	} else if function == "getVouchers" {
+		fmt.Println("Getting the vouchers")
+		return getVouchers(stub, args)
+	} else if function == "getMatchingTotals" {
+		fmt.Println("Getting the matching totals")
+		return getMatchingTotals(stub, args)
+	} else if function == "checkSupply" {
+		fmt.Println("Checking the total supply")
+		return checkSupply(stub, args)
+	} else if function == "getPendingProposals" {
+		fmt.Println("Getting the pending proposals")
+		return getPendingProposals(stub, args)
+	} else if function == "getPendingTransfers" {
+		fmt.Println("Getting the pending transfers")
+		return getPendingTransfers(stub, args)
+	} else if function == "getAuthorizations" {
+		fmt.Println("Getting the authorizations")
+		return getAuthorizations(stub, args)
+	} else if function == "getTransferDetail" {
+		fmt.Println("Getting the transfer")
+		return getTransferDetail(stub, args)
+	} else if function == "getTransferHistory" {
+		fmt.Println("Getting the transfer history")
+		return getTransferHistory(stub, args)
+	} else if function == "getSchedules" {
+		fmt.Println("Getting the schedules")
+		return getSchedules(stub, args)
+	} else if function == "getScheduleRuns" {
+		fmt.Println("Getting the schedule runs")
+		return getScheduleRuns(stub, args)
+	} else if function == "getSpendingStatus" {
+		fmt.Println("Getting the spending status")
+		return getSpendingStatus(stub, args)
+	} else if function == "previewFee" {
+		fmt.Println("Previewing the transfer fee")
+		return previewFee(stub, args)
+	} else if function == "getCurrencies" {
+		fmt.Println("Getting the currencies")
+		return getCurrencies(stub, args)
+	} else if function == "getFxRate" {
+		fmt.Println("Getting the fx rate")
+		return getFxRate(stub, args)
+	} else if function == "getAllowances" {
+		fmt.Println("Getting the allowances")
+		return getAllowances(stub, args)
+	} else if function == "getPendingSpends" {
+		fmt.Println("Getting the pending spends")
+		return getPendingSpends(stub, args)
+	} else if function == "trialBalance" {
+		fmt.Println("Getting the trial balance")
+		return trialBalance(stub, args)
+	} else if function == "journal" {
+		fmt.Println("Getting the journal")
+		return journal(stub, args)
+	} else if function == "getStatement" {
+		fmt.Println("Getting the statement")
+		return getStatement(stub, args)
+	} else if function == "getDonationReceipts" {
+		fmt.Println("Getting the donation receipts")
+		return getDonationReceipts(stub, args)
+	} else if function == "getDonationSummary" {
+		fmt.Println("Getting the donation summary")
+		return getDonationSummary(stub, args)
+	} else if function == "getProfileHistory" {
+		fmt.Println("Getting the profile history")
+		return getProfileHistory(stub, args)
+	} else if function == "getVerificationStatus" {
+		fmt.Println("Getting the verification status")
+		return getVerificationStatus(stub, args)
+	} else if function == "getBlocklist" {
+		fmt.Println("Getting the blocklist")
+		return getBlocklist(stub, args)
+	} else if function == "getScreeningHits" {
+		fmt.Println("Getting the screening hits")
+		return getScreeningHits(stub, args)
+	} else if function == "getOpenFlags" {
+		fmt.Println("Getting the open flags")
+		return getOpenFlags(stub, args)
+	} else if function == "getPayeeWhitelist" {
+		fmt.Println("Getting the payee whitelist")
+		return getPayeeWhitelist(stub, args)
 	}
 	fmt.Printf("=========================Error in Query=====================")
 	return nil, errors.New("Invalid query function name. Expecting \"query\"")
@@ -354,9 +710,220 @@ func GetCompany(companyID string, stub shim.ChaincodeStubInterface) (Account, er
 		return company, errors.New("Error unmarshalling account " + companyID)
 	}
 
+	syncBalances(&company)
 	return company, nil
 }
 
+// putAccount writes an account back to the ledger under its acct: key
+func putAccount(stub shim.ChaincodeStubInterface, account Account) error {
+	syncBalances(&account)
+	accountBytes, err := json.Marshal(&account)
+	if err != nil {
+		fmt.Println("Error marshalling account " + account.ID)
+		return errors.New("Error marshalling account " + account.ID)
+	}
+	err = stub.PutState(accountPrefix+account.ID, accountBytes)
+	if err != nil {
+		fmt.Println("Error writing account " + account.ID)
+		return errors.New("Error writing account " + account.ID)
+	}
+	return nil
+}
+
+// recordTransfer stamps a transfer with its id and time, stores it and books it in the journal
+func recordTransfer(stub shim.ChaincodeStubInterface, transfer Transfer) (Transfer, error) {
+	var err error
+	transfer.Time, err = txTimestampMs(stub)
+	if err != nil {
+		return transfer, err
+	}
+	transfer.ID, err = nextID(stub, "TXF")
+	if err != nil {
+		return transfer, err
+	}
+	transfer.TxID = stub.GetTxID()
+	err = putTransfer(stub, transfer)
+	if err != nil {
+		return transfer, err
+	}
+	err = indexTransfer(stub, transfer)
+	if err != nil {
+		return transfer, err
+	}
+	return transfer, journalTransfer(stub, transfer)
+}
+
+func putTransfer(stub shim.ChaincodeStubInterface, transfer Transfer) error {
+	transferBytes, err := json.Marshal(&transfer)
+	if err != nil {
+		fmt.Println("Error marshalling transfer " + transfer.ID)
+		return errors.New("Error marshalling transfer " + transfer.ID)
+	}
+	err = stub.PutState(transferPrefix+transfer.ID, transferBytes)
+	if err != nil {
+		fmt.Println("Error writing transfer " + transfer.ID)
+		return errors.New("Error writing transfer " + transfer.ID)
+	}
+	return nil
+}
+
+func getTransfer(stub shim.ChaincodeStubInterface, transferID string) (Transfer, error) {
+	var transfer Transfer
+	transferBytes, err := stub.GetState(transferPrefix + transferID)
+	if err != nil || len(transferBytes) == 0 {
+		fmt.Println("Transfer not found " + transferID)
+		return transfer, errors.New("Transfer not found " + transferID)
+	}
+
+	err = json.Unmarshal(transferBytes, &transfer)
+	if err != nil {
+		fmt.Println("Error unmarshalling transfer " + transferID + "\n err:" + err.Error())
+		return transfer, errors.New("Error unmarshalling transfer " + transferID)
+	}
+	return transfer, nil
+}
+
+// availableBalance is the part of the cash balance that is not held
+func availableBalance(account Account) float64 {
+	return account.CashBalance - account.HeldBalance
+}
+
+// placeHold reserves amount of an account's cash so it can't be spent by other transfers
+func placeHold(stub shim.ChaincodeStubInterface, id string, amount float64) error {
+	account, err := GetCompany(id, stub)
+	if err != nil {
+		return err
+	}
+	if availableBalance(account) < amount {
+		fmt.Println("===============The account " + id + " doesn't have enough cash to hold " + strconv.FormatFloat(amount, 'f', 2, 64))
+		return errors.New("The account " + id + " doesn't have enough cash to hold " + strconv.FormatFloat(amount, 'f', 2, 64))
+	}
+	account.HeldBalance += amount
+	return putAccount(stub, account)
+}
+
+// releaseHold returns held cash to the account's available balance
+func releaseHold(stub shim.ChaincodeStubInterface, id string, amount float64) error {
+	account, err := GetCompany(id, stub)
+	if err != nil {
+		return err
+	}
+	if account.HeldBalance < amount-0.005 {
+		return errors.New("The account " + id + " doesn't hold " + strconv.FormatFloat(amount, 'f', 2, 64))
+	}
+	account.HeldBalance -= amount
+	if account.HeldBalance < 0.005 {
+		account.HeldBalance = 0
+	}
+	return putAccount(stub, account)
+}
+
+// accountType maps the prefix suffix assigned in createAccount back to the user type
+func accountType(account Account) string {
+	if len(account.Prefix) == 0 {
+		return ""
+	}
+	switch account.Prefix[len(account.Prefix)-1:] {
+	case "A":
+		return "ADMIN"
+	case "C":
+		return "CORPORATE"
+	case "N":
+		return "NGO"
+	case "V":
+		return "VENDOR"
+	}
+	return ""
+}
+
+// getAccountOfType loads an account and checks it was created with the given user type
+func getAccountOfType(stub shim.ChaincodeStubInterface, id string, usertype string) (Account, error) {
+	account, err := GetCompany(id, stub)
+	if err != nil {
+		return account, err
+	}
+	if accountType(account) != usertype {
+		fmt.Println("===================Account " + id + " is not of type " + usertype)
+		return account, errors.New("Account " + id + " is not a " + usertype + " account")
+	}
+	// Admin rights are only used by the admin's own user
+	if usertype == "ADMIN" {
+		admin, err := isAdmin(stub, id)
+		if err != nil {
+			return account, err
+		}
+		if !admin {
+			return account, errors.New("Account " + id + " is not in the admin set")
+		}
+		err = checkCaller(stub, id)
+		if err != nil {
+			return account, err
+		}
+	}
+	return account, nil
+}
+
+// txTimestampMs returns the transaction timestamp in milliseconds, the same unit msToTime takes
+func txTimestampMs(stub shim.ChaincodeStubInterface) (int64, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		fmt.Println("Error getting transaction timestamp")
+		return 0, errors.New("Error getting transaction timestamp")
+	}
+	return ts.Seconds*millisPerSecond + int64(ts.Nanos)/int64(time.Millisecond), nil
+}
+
+// nextSequence increments the named ledger counter and returns the new value
+func nextSequence(stub shim.ChaincodeStubInterface, name string) (int64, error) {
+	var seq int64
+	seqBytes, err := stub.GetState(seqPrefix + name)
+	if err != nil {
+		return 0, errors.New("Error reading sequence " + name)
+	}
+	if len(seqBytes) > 0 {
+		seq, err = strconv.ParseInt(string(seqBytes), 10, 64)
+		if err != nil {
+			return 0, errors.New("Error reading sequence " + name)
+		}
+	}
+	seq++
+	err = stub.PutState(seqPrefix+name, []byte(strconv.FormatInt(seq, 10)))
+	if err != nil {
+		return 0, errors.New("Error writing sequence " + name)
+	}
+	return seq, nil
+}
+
+// nextID builds a record ID from a ledger sequence, zero padded so keys sort in creation order
+func nextID(stub shim.ChaincodeStubInterface, name string) (string, error) {
+	seq, err := nextSequence(stub, name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%08d", name, seq), nil
+}
+
+// scanPrefix calls fn for every key stored under prefix
+func scanPrefix(stub shim.ChaincodeStubInterface, prefix string, fn func(key string, value []byte) error) error {
+	iter, err := stub.RangeQueryState(prefix, prefix+"~")
+	if err != nil {
+		return errors.New("Error reading keys with prefix " + prefix)
+	}
+	defer iter.Close()
+
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return errors.New("Error reading keys with prefix " + prefix)
+		}
+		err = fn(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 //===========================end============get company info=================================================
 
 //===========================start============Account creation=================================================
@@ -364,7 +931,8 @@ func (t *SimpleChaincode) createAccount(stub shim.ChaincodeStubInterface, args [
 	fmt.Println("Creating account")
 
 	// Obtain the username to associate with the account
-	if len(args) != 3 {
+	// args: username, usertype, opening balance[, admin minting the opening balance[, currency]]
+	if len(args) < 3 || len(args) > 5 {
 		fmt.Println("====================Error obtaining username")
 		return nil, errors.New("Invalid number of argument")
 	}
@@ -385,14 +953,66 @@ func (t *SimpleChaincode) createAccount(stub shim.ChaincodeStubInterface, args [
 		return nil, errors.New("Invalid account type")
 	}
 
+	// Blocked names get a compliance event, and its id back, instead of an account
+	hit, err := screenName(stub, username, "")
+	if err != nil {
+		return nil, err
+	}
+	if hit != nil {
+		return recordComplianceEvent(stub, ComplianceEvent{Type: complianceScreeningHit, Action: "CREATE_ACCOUNT", Subject: username, Match: hit, Parties: []string{username},
+			Details: "create " + usertype + " account " + username})
+	}
+
 	amount, err := strconv.ParseFloat(args[2], 64)
 	if err != nil {
 		fmt.Println("===============Invalid Amount" + username)
 		return nil, errors.New("Invalid Amount " + args[2] + " for " + username)
 	}
+
+	currency, err := currencyArg(stub, args, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	// Admins start empty and can only be added by an existing admin, apart from the ones Init
+	// sets up. With a mint quorum the other admins have to approve, and the proposal id comes back.
+	if usertype == "ADMIN" {
+		if len(args) < 4 || amount != 0 {
+			fmt.Println("===============Admin account without admin for " + username)
+			return nil, errors.New("Admin account " + username + " has to be opened empty by an admin")
+		}
+		_, err = getAccountOfType(stub, args[3], "ADMIN")
+		if err != nil {
+			return nil, errors.New("Invalid Reuest to create admin account for " + args[3])
+		}
+		return requestAdmin(stub, args[3], username)
+	}
+
+	// Accounts start empty unless an admin mints the opening balance
+	var mintedBy string
+	if amount != 0 {
+		if len(args) < 4 || amount < 0 {
+			fmt.Println("===============Opening balance without admin for " + username)
+			return nil, errors.New("Opening balance for " + username + " has to be minted by an admin")
+		}
+		mintedBy = args[3]
+		_, err = getAccountOfType(stub, mintedBy, "ADMIN")
+		if err != nil {
+			return nil, errors.New("Invalid Reuest to mint opening balance for " + mintedBy)
+		}
+		quorum, err := getMintQuorum(stub)
+		if err != nil {
+			return nil, err
+		}
+		if quorum != nil && quorum.RequiredApprovals > 1 && amount > quorum.Threshold {
+			return nil, errors.New("Opening balance for " + username + " is above the mint quorum threshold, mint it after creating the account")
+		}
+	}
+
 	// Build an account object for the user
 	prefix := username + suffix
-	var account = Account{ID: username, Prefix: prefix, CashBalance: amount}
+	var account = Account{ID: username, Prefix: prefix}
+	adjustBalance(&account, currency, amount)
 	accountBytes, err := json.Marshal(&account)
 	if err != nil {
 		fmt.Println("===============error creating account" + account.ID)
@@ -414,7 +1034,7 @@ func (t *SimpleChaincode) createAccount(stub shim.ChaincodeStubInterface, args [
 
 				if err == nil {
 					fmt.Println("================created account" + accountPrefix + account.ID)
-					return accountBytes, nil
+					return accountBytes, recordOpeningMint(stub, mintedBy, account.ID, amount, currency)
 				} else {
 					fmt.Println("==============failed to create initialize account for " + account.ID)
 					return nil, errors.New("Failed to initialize an account for " + account.ID + " => " + err.Error())
@@ -433,7 +1053,7 @@ func (t *SimpleChaincode) createAccount(stub shim.ChaincodeStubInterface, args [
 
 		if err == nil {
 			fmt.Println("============created account" + accountPrefix + account.ID)
-			return accountBytes, nil
+			return accountBytes, recordOpeningMint(stub, mintedBy, account.ID, amount, currency)
 		} else {
 			fmt.Println("==============failed to create initialize account for " + account.ID)
 			return nil, errors.New("Failed to initialize an account for " + account.ID + " => " + err.Error())
@@ -484,7 +1104,7 @@ func (t *SimpleChaincode) createAccount(stub shim.ChaincodeStubInterface, args [
 //===========================end============Account creation=================================================
 
 //===========================start============standard value =================================================
-//lookup tables for last two digits of CUSIP
+// lookup tables for last two digits of CUSIP
 var seventhDigit = map[int]string{
 	1:  "A",
 	2:  "B",
@@ -549,59 +1169,260 @@ var eigthDigit = map[int]string{
 //===========================end============standard value=================================================
 
 //===========================start============transaction function=================================================
+// args: from, to, amount, memo[, currency]
 func (t *SimpleChaincode) transaction(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	fmt.Println("====================Transferring amount to user.=========================")
 
-	if len(args) != 4 {
+	if len(args) != 4 && len(args) != 5 {
 		return nil, errors.New("Incorrect number of arguments. Expecting commercial paper record")
 	}
 
+	amountToBeTransferred, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		fmt.Println("===================Error converting amount to float " + args[2])
+		return nil, errors.New("==============Error converting amount to float " + args[2])
+	}
+
+	currency, err := currencyArg(stub, args, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	// Payments to or from blocked accounts get a compliance event, and its id back, instead of a transfer
+	err = checkParties(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// Accounts with a payee whitelist can only pay the payees on it
+	err = checkPayee(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	// Held transactions return the aml flag id until they're cleared
+	result, err := submitTransfer(stub, args[0], args[1], amountToBeTransferred, currency, args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Successfully Transaction completed ====***====================")
+	return result, nil
+}
+
+// submitTransfer carries out a transaction that passed screening. Multisig accounts only
+// spend once enough signers approve, so they get back the pending spend id rather than a
+// transfer id.
+func submitTransfer(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, currency string, memo string) ([]byte, error) {
+	multisig, err := getMultisig(stub, fromID)
+	if err != nil {
+		return nil, err
+	}
+	if multisig != nil {
+		return createPendingSpend(stub, *multisig, toID, amount, currency, memo)
+	}
+
+	transferID, err := transferFundsIn(stub, fromID, toID, amount, currency, memo)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(transferID), nil
+}
+
+// transferFunds moves amount from one account to another and returns the id of the
+// transfer record. Every function that pays out of an account goes through here so
+// the balance checks stay in one place.
+func transferFunds(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, memo string) (string, error) {
+	return transferFundsIn(stub, fromID, toID, amount, baseCurrency, memo)
+}
+
+// transferFundsIn is transferFunds for any registered currency. Multisig accounts can only
+// pay through transaction, which waits for their signers.
+func transferFundsIn(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, currency string, memo string) (string, error) {
+	err := checkSingleSigner(stub, fromID)
+	if err != nil {
+		return "", err
+	}
+	return executeTransfer(stub, fromID, toID, amount, currency, memo)
+}
+
+// executeTransfer is transferFundsIn once any multisig signers have approved
+func executeTransfer(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, currency string, memo string) (string, error) {
+	checks, err := checkTransfer(stub, fromID, toID, amount, currency, memo)
+	if err != nil {
+		return "", err
+	}
+
+	// Base currency transfers leave Currency empty, as transfers did before currencies existed
+	transferIn := currency
+	if currency == baseCurrency {
+		transferIn = ""
+	}
+	transfer, err := moveFunds(stub, Transfer{From: fromID, To: toID, Amount: amount, Currency: transferIn, Memo: memo, Fee: checks.Fee.Fee, FeeCollector: checks.Fee.Collector})
+	if err != nil {
+		return "", err
+	}
+	err = completeTransfer(stub, transfer, checks)
+	if err != nil {
+		return "", err
+	}
+
+	// Donations into NGO accounts get a receipt and may be matched by a corporate program
+	err = issueDonationReceipt(stub, transfer)
+	if err != nil {
+		return "", err
+	}
+	err = applyDonationMatching(stub, transfer)
+	if err != nil {
+		return "", err
+	}
+	return transfer.ID, nil
+}
+
+// TransferChecks is what checkTransfer worked out for a transfer that passed its checks
+type TransferChecks struct {
+	Fee  FeePreview
+	Flag *AmlFlag
+}
+
+// checkTransfer runs the checks every payment out of an account passes before it moves any
+// money: screening and verification, the payee whitelist, the aml rules, spending limits at
+// the base currency value and room for any fee, which is charged in the transfer's currency.
+func checkTransfer(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, currency string, memo string) (TransferChecks, error) {
+	var checks TransferChecks
+	err := checkParties(stub, fromID, toID)
+	if err != nil {
+		return checks, err
+	}
+	err = checkPayee(stub, fromID, toID)
+	if err != nil {
+		return checks, err
+	}
+	checks.Flag, err = checkAml(stub, fromID, toID, amount, currency, memo)
+	if err != nil {
+		return checks, err
+	}
+	err = checkSpendingLimits(stub, fromID, amount, currency)
+	if err != nil {
+		return checks, err
+	}
+
+	// The payer covers any fee on top of the amount, so check both fit before moving either
+	checks.Fee, err = calculateFee(stub, fromID, toID, amount, currency)
+	if err != nil {
+		return checks, err
+	}
+	if checks.Fee.Fee > 0 {
+		payer, err := GetCompany(fromID, stub)
+		if err != nil {
+			return checks, err
+		}
+		if spendableIn(payer, currency) < checks.Fee.Total {
+			fmt.Println("===============The company " + fromID + " doesn't have enough cash to cover the fee")
+			return checks, errors.New("The company " + fromID + " doesn't have enough cash to cover " + strconv.FormatFloat(amount, 'f', 2, 64) + " plus a fee of " + strconv.FormatFloat(checks.Fee.Fee, 'f', 2, 64))
+		}
+	}
+	return checks, nil
+}
+
+// completeTransfer follows up a recorded transfer that passed checkTransfer: it charges the
+// fee, counts the transfer towards the payer's limits and records any aml flag against it
+func completeTransfer(stub shim.ChaincodeStubInterface, transfer Transfer, checks TransferChecks) error {
+	if checks.Fee.Fee > 0 {
+		_, err := moveFunds(stub, Transfer{From: transfer.From, To: checks.Fee.Collector, Amount: checks.Fee.Fee, Currency: transfer.Currency, Memo: "Fee on " + transfer.ID, FeeOf: transfer.ID})
+		if err != nil {
+			return err
+		}
+	}
+	err := recordSpending(stub, transfer.From, transfer.Amount, transfer.Currency)
+	if err != nil {
+		return err
+	}
+	if checks.Flag != nil {
+		checks.Flag.Result = transfer.ID
+		err = putAmlFlag(stub, *checks.Flag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParties refuses a transfer unless both accounts may take part in one. Anything that
+// moves money between accounts checks this first, moveFunds being the only exception.
+// A blocked account stops the transfer with a compliance event rather than a plain error.
+func checkParties(stub shim.ChaincodeStubInterface, fromID string, toID string) error {
+	err := screenParties(stub, fromID, toID)
+	if err != nil {
+		return err
+	}
+	err = checkVerified(stub, fromID)
+	if err != nil {
+		return err
+	}
+	return checkVerified(stub, toID)
+}
+
+// moveFunds does the balance update behind transferFunds without any of the follow-up
+// transfers, so those follow-ups can use it without triggering themselves again.
+// It records the transfer and returns it with its id filled in.
+func moveFunds(stub shim.ChaincodeStubInterface, transfer Transfer) (Transfer, error) {
+	fromID := transfer.From
+	toID := transfer.To
+	amount := transfer.Amount
+	currency := transferCurrency(transfer)
+
+	if fromID == toID {
+		return transfer, errors.New("Cannot transfer from " + fromID + " to itself")
+	}
+	if amount <= 0.0 {
+		fmt.Println("===============Invalid Amount ================")
+		return transfer, errors.New("Invalid Amount value")
+	}
+
 	var fromUser Account
-	fmt.Println("==============Getting State on fromUser " + args[0] + "================")
-	fromUserBytes, err := stub.GetState(accountPrefix + args[0])
+	fmt.Println("==============Getting State on fromUser " + fromID + "================")
+	fromUserBytes, err := stub.GetState(accountPrefix + fromID)
 	if err != nil {
-		fmt.Println("===================Account not found " + args[0] + "================")
-		return nil, errors.New("Account not found " + args[0])
+		fmt.Println("===================Account not found " + fromID + "================")
+		return transfer, errors.New("Account not found " + fromID)
 	}
 
 	fmt.Println("===============Unmarshalling FromCompany ================")
 	err = json.Unmarshal(fromUserBytes, &fromUser)
 	if err != nil {
-		fmt.Println("===================Error unmarshalling account " + args[0])
-		return nil, errors.New("Error unmarshalling account " + args[0])
+		fmt.Println("===================Error unmarshalling account " + fromID)
+		return transfer, errors.New("Error unmarshalling account " + fromID)
 	}
 
 	var toUser Account
-	fmt.Println("=====================Getting State on ToCompany " + args[1] + "================")
-	toUserBytes, err := stub.GetState(accountPrefix + args[1])
+	fmt.Println("=====================Getting State on ToCompany " + toID + "================")
+	toUserBytes, err := stub.GetState(accountPrefix + toID)
 	if err != nil {
-		fmt.Println("Account not found " + args[1] + "================")
-		return nil, errors.New("Account not found " + args[1])
+		fmt.Println("Account not found " + toID + "================")
+		return transfer, errors.New("Account not found " + toID)
 	}
 
 	fmt.Println("==================Unmarshalling tocompany================")
 	err = json.Unmarshal(toUserBytes, &toUser)
 	if err != nil {
-		fmt.Println("Error unmarshalling account " + args[1] + "================")
-		return nil, errors.New("Error unmarshalling account " + args[1])
+		fmt.Println("Error unmarshalling account " + toID + "================")
+		return transfer, errors.New("Error unmarshalling account " + toID)
 	}
 
-	amountToBeTransferred, err := strconv.ParseFloat(args[2], 64)
-	if err != nil {
-		fmt.Println("===================Error converting amount to float " + args[2])
-		return nil, errors.New("==============Error converting amount to float " + args[2])
-	}
+	amountStr := strconv.FormatFloat(amount, 'f', 2, 64)
 
 	// If fromCompany doesn't have enough cash to buy the papers
-	if fromUser.CashBalance < amountToBeTransferred {
-		fmt.Println("===============The company " + args[0] + "doesn't have enough cash to complete the transaction")
-		return nil, errors.New("The company " + args[0] + "doesn't have enough cash to complete the transaction")
+	// Held funds are already promised elsewhere and can't be spent
+	if spendableIn(fromUser, currency) < amount {
+		fmt.Println("===============The company " + fromID + "doesn't have enough cash to complete the transaction")
+		return transfer, errors.New("The company " + fromID + "doesn't have enough cash to complete the transaction")
 	} else {
-		fmt.Println("===================The " + args[0] + " has enough money to be transferred amount = " + args[2] + "==========")
+		fmt.Println("===================The " + fromID + " has enough money to be transferred amount = " + amountStr + "==========")
 	}
 
-	toUser.CashBalance += amountToBeTransferred
-	fromUser.CashBalance -= amountToBeTransferred
+	adjustBalance(&toUser, currency, amount)
+	adjustBalance(&fromUser, currency, -amount)
 
 	// Write everything back
 	// To Company
@@ -609,13 +1430,13 @@ func (t *SimpleChaincode) transaction(stub shim.ChaincodeStubInterface, args []s
 	toUserBytesToWrite, err := json.Marshal(&toUser)
 	if err != nil {
 		fmt.Println("=============Error marshalling the toCompany")
-		return nil, errors.New("Error marshalling the toCompany")
+		return transfer, errors.New("Error marshalling the toCompany")
 	}
-	fmt.Println("==============Put state on toCompany========amt = %f " + strconv.FormatFloat(toUser.CashBalance, 'f', 6, 64) + "==========")
-	err = stub.PutState(accountPrefix+args[1], toUserBytesToWrite)
+	fmt.Println("==============Put state on toCompany========amt = " + strconv.FormatFloat(balanceIn(toUser, currency), 'f', 6, 64) + " " + currency + "==========")
+	err = stub.PutState(accountPrefix+toID, toUserBytesToWrite)
 	if err != nil {
 		fmt.Println("===============Error writing the toCompany back")
-		return nil, errors.New("Error writing the toCompany back")
+		return transfer, errors.New("Error writing the toCompany back")
 	}
 
 	// From company
@@ -623,74 +1444,54 @@ func (t *SimpleChaincode) transaction(stub shim.ChaincodeStubInterface, args []s
 	fromUserBytesToWrite, err := json.Marshal(&fromUser)
 	if err != nil {
 		fmt.Println("===============Error marshalling the fromCompany=================")
-		return nil, errors.New("Error marshalling the fromCompany")
+		return transfer, errors.New("Error marshalling the fromCompany")
 	}
-	fmt.Println("==============Put state on fromCompany amt = %f" + strconv.FormatFloat(fromUser.CashBalance, 'f', 6, 64) + "==============")
-	err = stub.PutState(accountPrefix+args[0], fromUserBytesToWrite)
+	fmt.Println("==============Put state on fromCompany amt = " + strconv.FormatFloat(balanceIn(fromUser, currency), 'f', 6, 64) + " " + currency + "==============")
+	err = stub.PutState(accountPrefix+fromID, fromUserBytesToWrite)
 	if err != nil {
 		fmt.Println("================Error writing the fromCompany back")
-		return nil, errors.New("Error writing the fromCompany back")
+		return transfer, errors.New("Error writing the fromCompany back")
 	}
 
-	fmt.Println("==================***=== Successfully Transaction completed ====***====================")
-	return nil, nil
+	return recordTransfer(stub, transfer)
 }
 
 //===========================end============transaction function=================================================
 
 //===========================start============admin amount update function=================================================
+// args: admin, amount[, currency]
 func (t *SimpleChaincode) adminamtupdate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	fmt.Println("====================Admin Amount Update.=========================")
 
-	if len(args) != 2 {
+	if len(args) != 2 && len(args) != 3 {
 		return nil, errors.New("Incorrect number of arguments. Expecting commercial paper record")
 	}
-	var fromUser Account
-	fmt.Println("==============Getting State on fromUser " + args[0] + "================")
-	fromUserBytes, err := stub.GetState(accountPrefix + args[0])
-	if err != nil {
-		fmt.Println("===================Account not found " + args[0] + "================")
-		return nil, errors.New("Account not found " + args[0])
-	}
-
-	fmt.Println("===============Unmarshalling fromUser ================")
-	err = json.Unmarshal(fromUserBytes, &fromUser)
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
 	if err != nil {
-		fmt.Println("===================Error unmarshalling account " + args[0])
-		return nil, errors.New("Error unmarshalling account " + args[0])
-	}
-
-	n := len(fromUser.Prefix)
-
-	if string(fromUser.Prefix[n-1]) != "A" {
 		fmt.Println("===================Invalid request")
 		return nil, errors.New("Invalid Reuest to update amount for " + args[0])
 	}
 
 	amountToBeupdated, err := strconv.ParseFloat(args[1], 64)
-
-	if amountToBeupdated <= 0.0 {
+	if err != nil || amountToBeupdated <= 0.0 {
 		fmt.Println("===============Invalid Amount ================")
 		return nil, errors.New("Invalid Amount value")
 	}
 
-	fromUser.CashBalance += amountToBeupdated
-
-	fmt.Println("============= marshalling the user=================")
-	toUserBytesToWrite, err := json.Marshal(&fromUser)
+	currency, err := currencyArg(stub, args, 2)
 	if err != nil {
-		fmt.Println("=============Error marshalling the toCompany")
-		return nil, errors.New("Error marshalling the toCompany")
+		return nil, err
 	}
-	fmt.Println("==============Put state on toCompany========amt = %f " + strconv.FormatFloat(fromUser.CashBalance, 'f', 6, 64) + "==========")
-	err = stub.PutState(accountPrefix+args[0], toUserBytesToWrite)
+
+	// The admin's own balance is topped up by minting, so the total supply stays right.
+	// Large amounts wait for the mint quorum and return the proposal id instead.
+	proposalID, err := requestMint(stub, args[0], args[0], amountToBeupdated, currency)
 	if err != nil {
-		fmt.Println("===============Error writing the toCompany back")
-		return nil, errors.New("Error writing the toCompany back")
+		return nil, err
 	}
 
 	fmt.Println("==================***=== Successfully amount updated ====***====================")
-	return nil, nil
+	return proposalID, nil
 }
 
 //===========================end============admin amount update function=================================================
@@ -0,0 +1,191 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========currency records===============
+// baseCurrency is the currency CashBalance is kept in. Holds, spending limits and
+// donation matching work in the base currency only.
+const baseCurrency = "USD"
+
+var currencyPrefix = "currency:"
+
+type Currency struct {
+	Code         string `json:"code"`
+	Name         string `json:"name"`
+	RegisteredBy string `json:"registeredBy"`
+	RegisteredAt int64  `json:"registeredAt"`
+}
+
+//============end==========currency records===============
+
+func getCurrency(stub shim.ChaincodeStubInterface, code string) (Currency, error) {
+	var currency Currency
+	if code == baseCurrency {
+		return Currency{Code: baseCurrency, Name: "Base currency"}, nil
+	}
+	currencyBytes, err := stub.GetState(currencyPrefix + code)
+	if err != nil || len(currencyBytes) == 0 {
+		fmt.Println("Currency not registered " + code)
+		return currency, errors.New("Currency not registered " + code)
+	}
+
+	err = json.Unmarshal(currencyBytes, &currency)
+	if err != nil {
+		fmt.Println("Error unmarshalling currency " + code + "\n err:" + err.Error())
+		return currency, errors.New("Error unmarshalling currency " + code)
+	}
+	return currency, nil
+}
+
+// currencyArg reads the optional currency argument at index i, defaulting to the base currency
+func currencyArg(stub shim.ChaincodeStubInterface, args []string, i int) (string, error) {
+	if len(args) <= i || args[i] == "" {
+		return baseCurrency, nil
+	}
+	_, err := getCurrency(stub, args[i])
+	if err != nil {
+		return "", err
+	}
+	return args[i], nil
+}
+
+// transferCurrency reads a transfer's currency, which is left empty for the base currency
+func transferCurrency(transfer Transfer) string {
+	if transfer.Currency == "" {
+		return baseCurrency
+	}
+	return transfer.Currency
+}
+
+// balanceIn returns an account's ledger balance in a currency
+func balanceIn(account Account, currency string) float64 {
+	if currency == baseCurrency {
+		return account.CashBalance
+	}
+	return account.Balances[currency]
+}
+
+// spendableIn returns what an account can spend in a currency. Only base currency funds are held.
+func spendableIn(account Account, currency string) float64 {
+	if currency == baseCurrency {
+		return availableBalance(account)
+	}
+	return account.Balances[currency]
+}
+
+// adjustBalance adds delta to an account's balance in a currency and keeps the base
+// currency entry of Balances in step with CashBalance
+func adjustBalance(account *Account, currency string, delta float64) {
+	if currency == baseCurrency {
+		account.CashBalance += delta
+	} else {
+		if account.Balances == nil {
+			account.Balances = map[string]float64{}
+		}
+		account.Balances[currency] += delta
+	}
+	syncBalances(account)
+}
+
+// syncBalances copies CashBalance into Balances so the map lists every currency held
+func syncBalances(account *Account) {
+	if account.Balances == nil {
+		account.Balances = map[string]float64{}
+	}
+	account.Balances[baseCurrency] = account.CashBalance
+	account.AvailableBalance = availableBalance(*account)
+}
+
+//===========================start============currency functions=================================================
+// registerCurrency lets accounts hold and transfer balances in a new currency
+// args: admin, currency code (three upper case letters), name
+func (t *SimpleChaincode) registerCurrency(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Registering currency.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, currency code and name")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to register currency for " + args[0])
+	}
+
+	code := args[1]
+	if len(code) != 3 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' || code[2] < 'A' || code[2] > 'Z' {
+		return nil, errors.New("Invalid currency code " + code)
+	}
+	_, err = getCurrency(stub, code)
+	if err == nil {
+		return nil, errors.New("Currency already registered " + code)
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	currency := Currency{Code: code, Name: args[2], RegisteredBy: args[0], RegisteredAt: now}
+	currencyBytes, err := json.Marshal(&currency)
+	if err != nil {
+		return nil, errors.New("Error marshalling currency " + code)
+	}
+	err = stub.PutState(currencyPrefix+code, currencyBytes)
+	if err != nil {
+		return nil, errors.New("Error writing currency " + code)
+	}
+
+	fmt.Println("==================***=== Currency " + code + " registered ====***====================")
+	return nil, nil
+}
+
+//===========================end============currency functions=================================================
+
+//===========================start============currency queries=================================================
+// getCurrencies lists the base currency and every registered currency
+func getCurrencies(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting none")
+	}
+
+	base, _ := getCurrency(stub, baseCurrency)
+	currencies := []Currency{base}
+	err := scanPrefix(stub, currencyPrefix, func(key string, value []byte) error {
+		var currency Currency
+		err := json.Unmarshal(value, &currency)
+		if err != nil {
+			return errors.New("Error unmarshalling currency " + key)
+		}
+		currencies = append(currencies, currency)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(currencies)
+}
+
+//===========================end============currency queries=================================================
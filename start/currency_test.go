@@ -0,0 +1,71 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestTransferInRegisteredCurrency(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "10")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "registerCurrency", "root", "EUR", "Euro")
+	s.mustInvoke("root", "mint", "root", "acme", "20", "EUR")
+
+	s.mustInvoke("acme", "transaction", "acme", "relief", "15", "gift", "EUR")
+	if balanceIn(s.account("relief"), "EUR") != 15 || balanceIn(s.account("acme"), "EUR") != 5 {
+		t.Errorf("relief holds %v EUR after a 15 EUR gift", balanceIn(s.account("relief"), "EUR"))
+	}
+	if s.balance("acme") != 10 || s.balance("relief") != 0 {
+		t.Errorf("EUR gift moved base currency")
+	}
+}
+
+func TestTransferNeedsNoRateWithoutValueRules(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "0")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("operator", "ADMIN", "0")
+	s.mustInvoke("root", "registerCurrency", "root", "EUR", "Euro")
+	s.mustInvoke("root", "mint", "root", "acme", "100", "EUR")
+	s.mustInvoke("root", "setSpendingLimit", "root", "ACCOUNT", "acme", "0", "0", "5")
+	s.mustInvoke("root", "setFeeCollector", "root", "operator")
+	s.mustInvoke("root", "setFeeRule", "root", "ANY", "ANY", "0", "10")
+
+	s.mustInvoke("acme", "transaction", "acme", "relief", "10", "gift", "EUR")
+	if balanceIn(s.account("relief"), "EUR") != 10 || balanceIn(s.account("operator"), "EUR") != 1 {
+		t.Errorf("EUR gift without an EUR rate credited relief %v", balanceIn(s.account("relief"), "EUR"))
+	}
+
+	s.mustInvoke("root", "setSpendingLimit", "root", "ACCOUNT", "acme", "50", "0", "0")
+	s.mustFail("acme", "transaction", "acme", "relief", "10", "gift", "EUR")
+}
+
+func TestTransferRefusesUnknownCurrencyAndShortBalance(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "10")
+	s.openAccount("relief", "NGO", "0")
+	s.mustFail("acme", "transaction", "acme", "relief", "1", "gift", "EUR")
+	s.mustFail("acme", "registerCurrency", "acme", "EUR", "Euro")
+	s.mustInvoke("root", "registerCurrency", "root", "EUR", "Euro")
+	s.mustInvoke("root", "mint", "root", "acme", "5", "EUR")
+
+	s.mustFail("acme", "transaction", "acme", "relief", "6", "gift", "EUR")
+	if balanceIn(s.account("relief"), "EUR") != 0 {
+		t.Errorf("relief was credited EUR acme did not hold")
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========fee records===============
+var feeRulePrefix = "fee:"
+var feeCollectorKey = "cfg:feeCollector"
+
+// FeeRule is charged on transfers between two account types, ANY matching every type.
+// The payer pays Flat plus Percent of the amount on top of the transfer. Flat is in the
+// base currency and charged at its value in the transfer's currency.
+type FeeRule struct {
+	FromType string  `json:"fromType"`
+	ToType   string  `json:"toType"`
+	Flat     float64 `json:"flat"`
+	Percent  float64 `json:"percent"`
+}
+
+type FeePreview struct {
+	Amount    float64  `json:"amount"`
+	Fee       float64  `json:"fee"`
+	Total     float64  `json:"total"`
+	Collector string   `json:"collector,omitempty"`
+	Rule      *FeeRule `json:"rule,omitempty"`
+}
+
+//============end==========fee records===============
+
+func validFeeType(usertype string) bool {
+	return usertype == "ANY" || usertype == "ADMIN" || usertype == "CORPORATE" || usertype == "NGO" || usertype == "VENDOR"
+}
+
+// calculateFee works out the fee on a transfer in a currency and the collector it goes to.
+// No collector configured, or no rule for the pair of account types, means no fee.
+func calculateFee(stub shim.ChaincodeStubInterface, fromID string, toID string, amount float64, currency string) (FeePreview, error) {
+	preview := FeePreview{Amount: amount, Total: amount}
+
+	collectorBytes, err := stub.GetState(feeCollectorKey)
+	if err != nil {
+		return preview, errors.New("Error reading fee collector")
+	}
+	collector := string(collectorBytes)
+	if collector == "" || collector == fromID {
+		return preview, nil
+	}
+
+	from, err := GetCompany(fromID, stub)
+	if err != nil {
+		return preview, err
+	}
+	to, err := GetCompany(toID, stub)
+	if err != nil {
+		return preview, err
+	}
+	fromType := accountType(from)
+	toType := accountType(to)
+
+	pairs := [][2]string{{fromType, toType}, {fromType, "ANY"}, {"ANY", toType}, {"ANY", "ANY"}}
+	for _, pair := range pairs {
+		ruleBytes, err := stub.GetState(feeRulePrefix + pair[0] + ":" + pair[1])
+		if err != nil {
+			return preview, errors.New("Error reading fee rule " + pair[0] + ":" + pair[1])
+		}
+		if len(ruleBytes) == 0 {
+			continue
+		}
+		var rule FeeRule
+		err = json.Unmarshal(ruleBytes, &rule)
+		if err != nil {
+			return preview, errors.New("Error unmarshalling fee rule " + pair[0] + ":" + pair[1])
+		}
+
+		// Only a flat fee needs a rate; percentages are taken in the transfer's currency
+		flat := rule.Flat
+		if flat != 0 {
+			flat, err = convertAmount(stub, rule.Flat, baseCurrency, currency)
+			if err != nil {
+				return preview, err
+			}
+		}
+		preview.Fee = math.Round((flat+amount*rule.Percent/100)*100) / 100
+		preview.Total = amount + preview.Fee
+		preview.Collector = collector
+		preview.Rule = &rule
+		break
+	}
+	return preview, nil
+}
+
+//===========================start============fee functions=================================================
+// setFeeCollector names the account that receives transfer fees
+// args: admin, collector account
+func (t *SimpleChaincode) setFeeCollector(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting fee collector.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin and collector account")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set fee collector for " + args[0])
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(feeCollectorKey, []byte(args[1]))
+	if err != nil {
+		return nil, errors.New("Error writing fee collector")
+	}
+	return nil, nil
+}
+
+// setFeeRule sets the fee on transfers from one account type to another. Zero flat and
+// percent values remove the rule.
+// args: admin, from type, to type (ADMIN, CORPORATE, NGO, VENDOR or ANY), flat fee, percent fee
+func (t *SimpleChaincode) setFeeRule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting fee rule.=========================")
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, from type, to type, flat fee and percent fee")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set fee rule for " + args[0])
+	}
+	if !validFeeType(args[1]) || !validFeeType(args[2]) {
+		return nil, errors.New("Invalid account type")
+	}
+
+	rule := FeeRule{FromType: args[1], ToType: args[2]}
+	rule.Flat, err = strconv.ParseFloat(args[3], 64)
+	if err != nil || rule.Flat < 0 {
+		return nil, errors.New("Invalid flat fee " + args[3])
+	}
+	rule.Percent, err = strconv.ParseFloat(args[4], 64)
+	if err != nil || rule.Percent < 0 || rule.Percent > 100 {
+		return nil, errors.New("Invalid percent fee " + args[4])
+	}
+
+	key := feeRulePrefix + rule.FromType + ":" + rule.ToType
+	if rule.Flat == 0 && rule.Percent == 0 {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, errors.New("Error removing fee rule " + key)
+		}
+		return nil, nil
+	}
+
+	ruleBytes, err := json.Marshal(&rule)
+	if err != nil {
+		return nil, errors.New("Error marshalling fee rule " + key)
+	}
+	err = stub.PutState(key, ruleBytes)
+	if err != nil {
+		return nil, errors.New("Error writing fee rule " + key)
+	}
+	return nil, nil
+}
+
+//===========================end============fee functions=================================================
+
+//===========================start============fee queries=================================================
+// previewFee shows the fee a transfer would be charged and the total the payer would pay
+// args: from, to, amount[, currency]
+func previewFee(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from, to, amount and optional currency")
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	currency, err := currencyArg(stub, args, 3)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := calculateFee(stub, args[0], args[1], amount, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(preview)
+}
+
+//===========================end============fee queries=================================================
@@ -0,0 +1,49 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestTransferPaysFeeToCollector(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "103")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("operator", "ADMIN", "0")
+	s.mustInvoke("root", "setFeeCollector", "root", "operator")
+	s.mustInvoke("root", "setFeeRule", "root", "CORPORATE", "ANY", "1", "2")
+
+	s.mustInvoke("acme", "transaction", "acme", "relief", "50", "gift")
+	if s.balance("relief") != 50 || s.balance("operator") != 2 || s.balance("acme") != 51 {
+		t.Errorf("fee of 1 plus 2%% on 50 paid the collector %v, want 2", s.balance("operator"))
+	}
+}
+
+func TestTransferRefusesUnaffordableFee(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "103")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("operator", "ADMIN", "0")
+	s.mustFail("acme", "setFeeRule", "acme", "CORPORATE", "ANY", "0", "0")
+	s.mustInvoke("root", "setFeeCollector", "root", "operator")
+	s.mustInvoke("root", "setFeeRule", "root", "CORPORATE", "ANY", "1", "2")
+
+	s.mustFail("acme", "transaction", "acme", "relief", "101", "gift")
+	if s.balance("relief") != 0 || s.balance("operator") != 0 || s.balance("acme") != 103 {
+		t.Errorf("transfer that could not cover its fee moved funds")
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========fx rate records===============
+var fxRatePrefix = "fxrate:"
+var fxMaxAgeKey = "cfg:fxMaxAge"
+
+// FxRate is how many units of To one unit of From buys, from EffectiveAt onwards
+type FxRate struct {
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Rate        float64 `json:"rate"`
+	EffectiveAt int64   `json:"effectiveAt"`
+	PublishedBy string  `json:"publishedBy"`
+}
+
+// FxQuote is the rate prevailing for a pair at a point in time. Inverted is set when only
+// the opposite pair was published and the quote uses its reciprocal.
+type FxQuote struct {
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Rate        float64 `json:"rate"`
+	EffectiveAt int64   `json:"effectiveAt"`
+	Inverted    bool    `json:"inverted,omitempty"`
+	Age         int64   `json:"age"`
+	MaxAge      int64   `json:"maxAge,omitempty"`
+	Stale       bool    `json:"stale"`
+}
+
+//============end==========fx rate records===============
+
+// fxRateKey keeps a pair's rates in effective time order
+func fxRateKey(from string, to string, effectiveAt int64) string {
+	return fmt.Sprintf("%s%s:%s:%013d", fxRatePrefix, from, to, effectiveAt)
+}
+
+func getFxMaxAge(stub shim.ChaincodeStubInterface) (int64, error) {
+	ageBytes, err := stub.GetState(fxMaxAgeKey)
+	if err != nil {
+		return 0, errors.New("Error reading fx rate max age")
+	}
+	if len(ageBytes) == 0 {
+		return 0, nil
+	}
+	maxAge, err := strconv.ParseInt(string(ageBytes), 10, 64)
+	if err != nil {
+		return 0, errors.New("Error reading fx rate max age")
+	}
+	return maxAge, nil
+}
+
+// latestFxRate finds the last rate published for a pair that is effective at now
+func latestFxRate(stub shim.ChaincodeStubInterface, from string, to string, now int64) (*FxRate, error) {
+	var latest *FxRate
+	err := scanPrefix(stub, fxRatePrefix+from+":"+to+":", func(key string, value []byte) error {
+		var rate FxRate
+		err := json.Unmarshal(value, &rate)
+		if err != nil {
+			return errors.New("Error unmarshalling fx rate " + key)
+		}
+		if rate.EffectiveAt <= now {
+			latest = &rate
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// quoteFx returns the prevailing rate for a pair, using the reciprocal of the opposite pair
+// when that is the only one published, and marks it stale when it is older than the max age
+func quoteFx(stub shim.ChaincodeStubInterface, from string, to string, now int64) (FxQuote, error) {
+	quote := FxQuote{From: from, To: to}
+
+	rate, err := latestFxRate(stub, from, to, now)
+	if err != nil {
+		return quote, err
+	}
+	inverse, err := latestFxRate(stub, to, from, now)
+	if err != nil {
+		return quote, err
+	}
+	if rate == nil || (inverse != nil && inverse.EffectiveAt > rate.EffectiveAt) {
+		if inverse == nil {
+			return quote, errors.New("No fx rate published for " + from + " to " + to)
+		}
+		quote.Rate = 1 / inverse.Rate
+		quote.EffectiveAt = inverse.EffectiveAt
+		quote.Inverted = true
+	} else {
+		quote.Rate = rate.Rate
+		quote.EffectiveAt = rate.EffectiveAt
+	}
+
+	quote.MaxAge, err = getFxMaxAge(stub)
+	if err != nil {
+		return quote, err
+	}
+	quote.Age = now - quote.EffectiveAt
+	quote.Stale = quote.MaxAge > 0 && quote.Age > quote.MaxAge
+	return quote, nil
+}
+
+// convertAmount values an amount in another currency at the prevailing rate, refusing stale rates
+func convertAmount(stub shim.ChaincodeStubInterface, amount float64, from string, to string) (float64, error) {
+	if from == to {
+		return amount, nil
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return 0, err
+	}
+	quote, err := quoteFx(stub, from, to, now)
+	if err != nil {
+		return 0, err
+	}
+	if quote.Stale {
+		fmt.Println("===================Fx rate " + from + ":" + to + " is stale")
+		return 0, errors.New("Fx rate " + from + ":" + to + " is " + strconv.FormatInt(quote.Age, 10) + "ms old, older than the allowed " + strconv.FormatInt(quote.MaxAge, 10) + "ms")
+	}
+	return math.Round(amount*quote.Rate*100) / 100, nil
+}
+
+//===========================start============fx rate functions=================================================
+// publishFxRate records a rate for a currency pair, effective from the given time
+// args: admin, from currency, to currency, rate, effective at (ms)
+func (t *SimpleChaincode) publishFxRate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Publishing fx rate.=========================")
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, from currency, to currency, rate and effective time")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to publish fx rate for " + args[0])
+	}
+	_, err = getCurrency(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	_, err = getCurrency(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if args[1] == args[2] {
+		return nil, errors.New("Cannot publish a rate from " + args[1] + " to itself")
+	}
+
+	rate := FxRate{From: args[1], To: args[2], PublishedBy: args[0]}
+	rate.Rate, err = strconv.ParseFloat(args[3], 64)
+	if err != nil || rate.Rate <= 0 {
+		return nil, errors.New("Invalid fx rate " + args[3])
+	}
+	rate.EffectiveAt, err = strconv.ParseInt(args[4], 10, 64)
+	if err != nil || rate.EffectiveAt < 0 {
+		return nil, errors.New("Invalid effective time " + args[4])
+	}
+
+	rateBytes, err := json.Marshal(&rate)
+	if err != nil {
+		return nil, errors.New("Error marshalling fx rate " + rate.From + ":" + rate.To)
+	}
+	err = stub.PutState(fxRateKey(rate.From, rate.To, rate.EffectiveAt), rateBytes)
+	if err != nil {
+		return nil, errors.New("Error writing fx rate " + rate.From + ":" + rate.To)
+	}
+
+	fmt.Println("==================***=== Fx rate " + rate.From + ":" + rate.To + " = " + args[3] + " published ====***====================")
+	return nil, nil
+}
+
+// setFxMaxAge sets how old, in milliseconds, a rate may be before conversions refuse it.
+// Zero accepts rates of any age.
+// args: admin, max age (ms)
+func (t *SimpleChaincode) setFxMaxAge(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting fx rate max age.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin and max age")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set fx rate max age for " + args[0])
+	}
+	maxAge, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || maxAge < 0 {
+		return nil, errors.New("Invalid max age " + args[1])
+	}
+
+	err = stub.PutState(fxMaxAgeKey, []byte(strconv.FormatInt(maxAge, 10)))
+	if err != nil {
+		return nil, errors.New("Error writing fx rate max age")
+	}
+	return nil, nil
+}
+
+// convertAndTransfer debits the payer in one currency and credits the payee the converted
+// amount in another at the prevailing rate. The source amount leaves the supply of its
+// currency and the converted amount joins the supply of the other, both logged as conversions.
+// It passes the same checks as any other transfer, with any fee charged in the from currency.
+// args: from, to, amount, from currency, to currency, memo
+func (t *SimpleChaincode) convertAndTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Converting and transferring.=========================")
+
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from, to, amount, from currency, to currency and memo")
+	}
+
+	fromID := args[0]
+	toID := args[1]
+	if fromID == toID {
+		return nil, errors.New("Cannot transfer from " + fromID + " to itself")
+	}
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	fromCurrency, err := currencyArg(stub, args, 3)
+	if err != nil {
+		return nil, err
+	}
+	toCurrency, err := currencyArg(stub, args, 4)
+	if err != nil {
+		return nil, err
+	}
+	if fromCurrency == toCurrency {
+		return nil, errors.New("Use transaction to transfer without converting " + fromCurrency)
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := quoteFx(stub, fromCurrency, toCurrency, now)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Stale {
+		fmt.Println("===================Fx rate " + fromCurrency + ":" + toCurrency + " is stale")
+		return nil, errors.New("Fx rate " + fromCurrency + ":" + toCurrency + " is " + strconv.FormatInt(quote.Age, 10) + "ms old, older than the allowed " + strconv.FormatInt(quote.MaxAge, 10) + "ms")
+	}
+	converted := math.Round(amount*quote.Rate*100) / 100
+	if converted <= 0 {
+		return nil, errors.New("Amount " + args[2] + " converts to nothing in " + toCurrency)
+	}
+
+	err = checkSingleSigner(stub, fromID)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := checkTransfer(stub, fromID, toID, amount, fromCurrency, args[5])
+	if err != nil {
+		return nil, err
+	}
+
+	payer, err := GetCompany(fromID, stub)
+	if err != nil {
+		return nil, err
+	}
+	payee, err := GetCompany(toID, stub)
+	if err != nil {
+		return nil, err
+	}
+	if spendableIn(payer, fromCurrency) < amount {
+		fmt.Println("===============The company " + fromID + " doesn't have enough " + fromCurrency + " to complete the transaction")
+		return nil, errors.New("The company " + fromID + " doesn't have enough " + fromCurrency + " to complete the transaction")
+	}
+
+	adjustBalance(&payer, fromCurrency, -amount)
+	err = putAccount(stub, payer)
+	if err != nil {
+		return nil, err
+	}
+	adjustBalance(&payee, toCurrency, converted)
+	err = putAccount(stub, payee)
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, supplyConvertOut, fromID, fromID, amount, fromCurrency)
+	if err != nil {
+		return nil, err
+	}
+	err = changeSupply(stub, supplyConvertIn, fromID, toID, converted, toCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := Transfer{From: fromID, To: toID, Amount: amount, Currency: fromCurrency, Memo: args[5],
+		Rate: quote.Rate, RateEffectiveAt: quote.EffectiveAt, Credited: converted, CreditCurrency: toCurrency,
+		Fee: checks.Fee.Fee, FeeCollector: checks.Fee.Collector}
+	if fromCurrency == baseCurrency {
+		transfer.Currency = ""
+	}
+	transfer, err = recordTransfer(stub, transfer)
+	if err != nil {
+		return nil, err
+	}
+	err = completeTransfer(stub, transfer, checks)
+	if err != nil {
+		return nil, err
+	}
+	// A conversion into an NGO is a donation like any other transfer
+	err = issueDonationReceipt(stub, transfer)
+	if err != nil {
+		return nil, err
+	}
+	err = applyDonationMatching(stub, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Converted " + args[2] + " " + fromCurrency + " to " + formatAmount(converted) + " " + toCurrency + " ====***====================")
+	return []byte(transfer.ID), nil
+}
+
+//===========================end============fx rate functions=================================================
+
+//===========================start============fx rate queries=================================================
+// getFxRate returns the rate prevailing for a currency pair and whether it is too old to use
+// args: from currency, to currency, as of (ms)
+func getFxRate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from currency, to currency and as of time")
+	}
+
+	asOf, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid as of time " + args[2])
+	}
+	quote, err := quoteFx(stub, args[0], args[1], asOf)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(quote)
+}
+
+//===========================end============fx rate queries=================================================
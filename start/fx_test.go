@@ -0,0 +1,50 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestConvertAndTransferAtPublishedRate(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "registerCurrency", "root", "EUR", "Euro")
+	s.mustInvoke("root", "publishFxRate", "root", "USD", "EUR", "0.9", "1000000")
+
+	s.mustInvoke("acme", "convertAndTransfer", "acme", "relief", "10", "USD", "EUR", "gift")
+	if s.balance("acme") != 90 || balanceIn(s.account("relief"), "EUR") != 9 {
+		t.Errorf("10 USD at 0.9 credited relief %v EUR, want 9", balanceIn(s.account("relief"), "EUR"))
+	}
+}
+
+func TestConvertAndTransferRefusesStaleOrMissingRates(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "registerCurrency", "root", "EUR", "Euro")
+	s.mustFail("acme", "convertAndTransfer", "acme", "relief", "10", "USD", "EUR", "gift")
+	s.mustFail("acme", "publishFxRate", "acme", "USD", "EUR", "100", "1000000")
+
+	s.mustInvoke("root", "publishFxRate", "root", "USD", "EUR", "0.9", "1000000")
+	s.mustInvoke("root", "setFxMaxAge", "root", "300000")
+	s.now += 600000
+	s.mustFail("acme", "convertAndTransfer", "acme", "relief", "10", "USD", "EUR", "gift")
+	if s.balance("acme") != 100 || balanceIn(s.account("relief"), "EUR") != 0 {
+		t.Errorf("conversion without a fresh rate moved funds")
+	}
+}
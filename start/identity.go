@@ -0,0 +1,53 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// callerAttribute is the transaction certificate attribute carrying the enrolled username
+// of whoever sent the transaction. Account ids are usernames, so it names the caller's account.
+const callerAttribute = "username"
+
+// getCaller returns the username the caller's certificate was issued for
+func getCaller(stub shim.ChaincodeStubInterface) (string, error) {
+	caller, err := stub.ReadCertAttribute(callerAttribute)
+	if err != nil || len(caller) == 0 {
+		fmt.Println("===================Caller has no " + callerAttribute + " attribute")
+		return "", errors.New("Could not read the caller's " + callerAttribute + " attribute")
+	}
+	return string(caller), nil
+}
+
+// checkCaller refuses a request made in the name of accountID unless accountID's own user
+// sent it. Functions that take the acting account as an argument check it with this.
+func checkCaller(stub shim.ChaincodeStubInterface, accountID string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if caller != accountID {
+		fmt.Println("===================Caller " + caller + " tried to act as " + accountID)
+		return errors.New("Caller " + caller + " cannot act as " + accountID)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========invoice records===============
+var invoicePrefix = "inv:"
+
+const (
+	invoiceOpen = "OPEN"
+	invoicePaid = "PAID"
+)
+
+type InvoiceLine struct {
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	UnitPrice   float64 `json:"unitPrice"`
+}
+
+type Invoice struct {
+	ID            string        `json:"id"`
+	Vendor        string        `json:"vendor"`
+	NGO           string        `json:"ngo"`
+	Amount        float64       `json:"amount"`
+	LineItems     []InvoiceLine `json:"lineItems"`
+	DueDate       int64         `json:"dueDate"`
+	DocumentHash  string        `json:"documentHash"`
+	PurchaseOrder string        `json:"purchaseOrder,omitempty"`
+	Status        string        `json:"status"`
+	SubmittedAt   int64         `json:"submittedAt"`
+	PaidAt        int64         `json:"paidAt,omitempty"`
+}
+
+//============end==========invoice records===============
+
+func getInvoice(stub shim.ChaincodeStubInterface, invoiceID string) (Invoice, error) {
+	var invoice Invoice
+	invoiceBytes, err := stub.GetState(invoicePrefix + invoiceID)
+	if err != nil || len(invoiceBytes) == 0 {
+		fmt.Println("Invoice not found " + invoiceID)
+		return invoice, errors.New("Invoice not found " + invoiceID)
+	}
+
+	err = json.Unmarshal(invoiceBytes, &invoice)
+	if err != nil {
+		fmt.Println("Error unmarshalling invoice " + invoiceID + "\n err:" + err.Error())
+		return invoice, errors.New("Error unmarshalling invoice " + invoiceID)
+	}
+	return invoice, nil
+}
+
+func putInvoice(stub shim.ChaincodeStubInterface, invoice Invoice) error {
+	invoiceBytes, err := json.Marshal(&invoice)
+	if err != nil {
+		fmt.Println("Error marshalling invoice " + invoice.ID)
+		return errors.New("Error marshalling invoice " + invoice.ID)
+	}
+	err = stub.PutState(invoicePrefix+invoice.ID, invoiceBytes)
+	if err != nil {
+		fmt.Println("Error writing invoice " + invoice.ID)
+		return errors.New("Error writing invoice " + invoice.ID)
+	}
+	return nil
+}
+
+// validateInvoiceLines checks every line has a quantity and price, that no item is on two
+// lines and that the lines add up to the invoice amount
+func validateInvoiceLines(lineItems []InvoiceLine, amount float64) error {
+	var total float64
+	seen := map[string]bool{}
+	for _, line := range lineItems {
+		if line.Quantity <= 0 || line.UnitPrice < 0 {
+			return errors.New("Invalid quantity or price on line item " + line.Description)
+		}
+		if seen[line.Description] {
+			return errors.New("Line item " + line.Description + " appears more than once")
+		}
+		seen[line.Description] = true
+		total += line.Quantity * line.UnitPrice
+	}
+	if math.Abs(total-amount) > 0.005 {
+		fmt.Println("===============Line items do not add up to " + formatAmount(amount))
+		return errors.New("Line items total " + formatAmount(total) + " does not match amount " + formatAmount(amount))
+	}
+	return nil
+}
+
+//===========================start============submit invoice=================================================
+// submitInvoice records an invoice from a VENDOR account addressed to an NGO account
+// args: vendor, ngo, amount, line items (JSON array), due date (ms), document hash[, purchase order id]
+func (t *SimpleChaincode) submitInvoice(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Submitting invoice.=========================")
+
+	if len(args) != 6 && len(args) != 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting vendor, ngo, amount, line items, due date, document hash and optional purchase order id")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "VENDOR")
+	if err != nil {
+		return nil, err
+	}
+	_, err = getAccountOfType(stub, args[1], "NGO")
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+
+	var lineItems []InvoiceLine
+	err = json.Unmarshal([]byte(args[3]), &lineItems)
+	if err != nil || len(lineItems) == 0 {
+		fmt.Println("===============Invalid line items " + args[3])
+		return nil, errors.New("Invalid line items for invoice")
+	}
+	err = validateInvoiceLines(lineItems, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	dueDate, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid due date " + args[4])
+	}
+	if args[5] == "" {
+		return nil, errors.New("Document hash is required")
+	}
+
+	var poID string
+	if len(args) == 7 && args[6] != "" {
+		po, err := getPurchaseOrder(stub, args[6])
+		if err != nil {
+			return nil, err
+		}
+		if po.Vendor != args[0] || po.NGO != args[1] {
+			return nil, errors.New("Purchase order " + po.ID + " was not placed by " + args[1] + " with " + args[0])
+		}
+		poID = po.ID
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	invoiceID, err := nextID(stub, "INV")
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := Invoice{
+		ID:            invoiceID,
+		Vendor:        args[0],
+		NGO:           args[1],
+		Amount:        amount,
+		LineItems:     lineItems,
+		DueDate:       dueDate,
+		DocumentHash:  args[5],
+		PurchaseOrder: poID,
+		Status:        invoiceOpen,
+		SubmittedAt:   now,
+	}
+	err = putInvoice(stub, invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Invoice " + invoiceID + " submitted ====***====================")
+	return []byte(invoiceID), nil
+}
+
+//===========================end============submit invoice=================================================
+
+//===========================start============pay invoice=================================================
+// payInvoice transfers the invoice amount from the NGO to the vendor and marks it paid
+// args: ngo, invoice id
+func (t *SimpleChaincode) payInvoice(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Paying invoice.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo and invoice id")
+	}
+
+	invoice, err := getInvoice(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if invoice.NGO != args[0] {
+		fmt.Println("===================Invoice " + invoice.ID + " is not addressed to " + args[0])
+		return nil, errors.New("Invoice " + invoice.ID + " is not addressed to " + args[0])
+	}
+	if invoice.Status != invoiceOpen {
+		return nil, errors.New("Invoice " + invoice.ID + " is already " + invoice.Status)
+	}
+
+	// Vendors are only paid when the purchase order, goods receipts and invoice agree
+	mismatches, err := threeWayMatch(stub, invoice)
+	if err != nil {
+		return nil, err
+	}
+	if len(mismatches) > 0 {
+		fmt.Println("===================Invoice " + invoice.ID + " failed three-way match")
+		return nil, errors.New("Invoice " + invoice.ID + " failed three-way match: " + strings.Join(mismatches, "; "))
+	}
+
+	_, err = transferFunds(stub, invoice.NGO, invoice.Vendor, invoice.Amount, "Invoice "+invoice.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = applyInvoiceToPurchaseOrder(stub, invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Status = invoicePaid
+	invoice.PaidAt = now
+	err = putInvoice(stub, invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Invoice " + invoice.ID + " paid ====***====================")
+	return nil, nil
+}
+
+//===========================end============pay invoice=================================================
+
+//===========================start============invoice queries=================================================
+// getInvoices lists invoices submitted by a vendor or addressed to an NGO
+// args: account, status (OPEN, PAID, OVERDUE or ALL), as of (ms, used to judge OVERDUE)
+func getInvoices(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account, status and as of time")
+	}
+
+	asOf, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid as of time " + args[2])
+	}
+	status := args[1]
+	if status != invoiceOpen && status != invoicePaid && status != "OVERDUE" && status != "ALL" {
+		return nil, errors.New("Invalid invoice status " + status)
+	}
+
+	invoices := []Invoice{}
+	err = scanPrefix(stub, invoicePrefix, func(key string, value []byte) error {
+		var invoice Invoice
+		err := json.Unmarshal(value, &invoice)
+		if err != nil {
+			return errors.New("Error unmarshalling invoice " + key)
+		}
+		if invoice.Vendor != args[0] && invoice.NGO != args[0] {
+			return nil
+		}
+		overdue := invoice.Status == invoiceOpen && invoice.DueDate < asOf
+		if status == "ALL" || status == invoice.Status || (status == "OVERDUE" && overdue) {
+			invoices = append(invoices, invoice)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(invoices)
+}
+
+//===========================end============invoice queries=================================================
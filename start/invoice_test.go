@@ -0,0 +1,72 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestValidateInvoiceLinesRejectsDuplicateItems(t *testing.T) {
+	lines := []InvoiceLine{
+		{Description: "tents", Quantity: 10, UnitPrice: 5},
+		{Description: "tents", Quantity: 10, UnitPrice: 5},
+	}
+	if validateInvoiceLines(lines, 100) == nil {
+		t.Errorf("invoice with tents on two lines was accepted")
+	}
+}
+
+func TestValidateInvoiceLinesChecksTotal(t *testing.T) {
+	lines := []InvoiceLine{
+		{Description: "tents", Quantity: 10, UnitPrice: 5},
+		{Description: "blankets", Quantity: 4, UnitPrice: 2.5},
+	}
+	if err := validateInvoiceLines(lines, 60); err != nil {
+		t.Errorf("valid lines: %v", err)
+	}
+	if validateInvoiceLines(lines, 70) == nil {
+		t.Errorf("lines adding up to 60 were accepted for 70")
+	}
+}
+
+func TestPayInvoice(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	po := string(s.mustInvoke("relief", "createPurchaseOrder", "relief", "tentco", `[{"description":"tents","quantity":3,"unitPrice":50}]`))
+	id := string(s.mustInvoke("tentco", "submitInvoice", "tentco", "relief", "150", `[{"description":"tents","quantity":3,"unitPrice":50}]`, "2000000000", "hash", po))
+	s.mustInvoke("relief", "recordGoodsReceipt", "relief", po, `[{"description":"tents","quantity":3}]`)
+
+	s.mustInvoke("relief", "payInvoice", "relief", id)
+	if s.balance("relief") != 850 || s.balance("tentco") != 150 {
+		t.Errorf("invoice paid %v from relief to tentco, want 150", s.balance("tentco"))
+	}
+	s.mustFail("relief", "payInvoice", "relief", id)
+}
+
+func TestPayInvoiceRefusesOtherNgo(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("shelter", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	id := string(s.mustInvoke("tentco", "submitInvoice", "tentco", "relief", "150", `[{"description":"tents","quantity":3,"unitPrice":50}]`, "2000000000", "hash"))
+
+	s.mustFail("shelter", "payInvoice", "shelter", id)
+	s.mustFail("tentco", "submitInvoice", "relief", "tentco", "150", `[{"description":"tents","quantity":3,"unitPrice":50}]`, "2000000000", "hash")
+	if s.balance("shelter") != 1000 || s.balance("tentco") != 0 {
+		t.Errorf("invoice for relief was paid by shelter")
+	}
+}
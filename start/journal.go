@@ -0,0 +1,330 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========journal records===============
+var journalPrefix = "journal:"
+
+// Chart of accounts. Every account's balance is a liability of the ledger to its holder,
+// kept as a sub-ledger of glAccountBalances by party. Minting money books it against
+// glIssuedFunds, and conversions pass through glFxConversion in each currency.
+const (
+	glIssuedFunds     = "1000"
+	glAccountBalances = "2000"
+	glFxConversion    = "3000"
+)
+
+var chartOfAccounts = map[string]string{
+	glIssuedFunds:     "Issued funds",
+	glAccountBalances: "Account balances",
+	glFxConversion:    "FX conversion",
+}
+
+type JournalLine struct {
+	Account  string  `json:"account"`
+	Party    string  `json:"party,omitempty"`
+	Currency string  `json:"currency"`
+	Debit    float64 `json:"debit,omitempty"`
+	Credit   float64 `json:"credit,omitempty"`
+}
+
+// JournalEntry is one balanced posting. Source says what moved the money and Reference
+// points at the transfer or supply change behind it.
+type JournalEntry struct {
+	ID        string        `json:"id"`
+	Source    string        `json:"source"`
+	Reference string        `json:"reference"`
+	Memo      string        `json:"memo,omitempty"`
+	Lines     []JournalLine `json:"lines"`
+	Time      int64         `json:"time"`
+	TxID      string        `json:"txId"`
+}
+
+type TrialBalanceLine struct {
+	Account  string  `json:"account"`
+	Name     string  `json:"name"`
+	Currency string  `json:"currency"`
+	Debit    float64 `json:"debit"`
+	Credit   float64 `json:"credit"`
+	Balance  float64 `json:"balance"`
+}
+
+// BalanceDifference is an account whose balance doesn't match what the journal booked to it
+// under glAccountBalances
+type BalanceDifference struct {
+	Party      string  `json:"party"`
+	Balance    float64 `json:"balance"`
+	Journal    float64 `json:"journal"`
+	Difference float64 `json:"difference"`
+}
+
+// TrialBalance is Balanced when debits equal credits, and Reconciled when every account's
+// balance matches its sub-ledger in the journal
+type TrialBalance struct {
+	Currency    string              `json:"currency"`
+	Lines       []TrialBalanceLine  `json:"lines"`
+	TotalDebit  float64             `json:"totalDebit"`
+	TotalCredit float64             `json:"totalCredit"`
+	Balanced    bool                `json:"balanced"`
+	Differences []BalanceDifference `json:"differences"`
+	Reconciled  bool                `json:"reconciled"`
+}
+
+//============end==========journal records===============
+
+// postJournal checks an entry balances in every currency and stores it
+func postJournal(stub shim.ChaincodeStubInterface, entry JournalEntry) error {
+	totals := map[string]float64{}
+	for _, line := range entry.Lines {
+		if _, ok := chartOfAccounts[line.Account]; !ok {
+			return errors.New("Unknown ledger account " + line.Account)
+		}
+		totals[line.Currency] += line.Debit - line.Credit
+	}
+	for currency, total := range totals {
+		if math.Abs(total) >= 0.005 {
+			fmt.Println("===================Journal entry for " + entry.Reference + " does not balance in " + currency)
+			return errors.New("Journal entry for " + entry.Reference + " does not balance in " + currency)
+		}
+	}
+
+	var err error
+	entry.Time, err = txTimestampMs(stub)
+	if err != nil {
+		return err
+	}
+	entry.ID, err = nextID(stub, "JNL")
+	if err != nil {
+		return err
+	}
+	entry.TxID = stub.GetTxID()
+
+	entryBytes, err := json.Marshal(&entry)
+	if err != nil {
+		return errors.New("Error marshalling journal entry " + entry.ID)
+	}
+	err = stub.PutState(journalPrefix+entry.ID, entryBytes)
+	if err != nil {
+		return errors.New("Error writing journal entry " + entry.ID)
+	}
+	return nil
+}
+
+// journalTransfer books a transfer between two accounts. Conversions leave the payer's
+// balance in one currency and reach the payee's in another through the FX conversion account.
+func journalTransfer(stub shim.ChaincodeStubInterface, transfer Transfer) error {
+	currency := transferCurrency(transfer)
+	entry := JournalEntry{Source: "TRANSFER", Reference: transfer.ID, Memo: transfer.Memo}
+	if transfer.FeeOf != "" {
+		entry.Source = "FEE"
+	} else if transfer.RefundOf != "" {
+		entry.Source = "REFUND"
+	}
+
+	if transfer.CreditCurrency == "" {
+		entry.Lines = []JournalLine{
+			{Account: glAccountBalances, Party: transfer.From, Currency: currency, Debit: transfer.Amount},
+			{Account: glAccountBalances, Party: transfer.To, Currency: currency, Credit: transfer.Amount},
+		}
+	} else {
+		entry.Source = "CONVERSION"
+		entry.Lines = []JournalLine{
+			{Account: glAccountBalances, Party: transfer.From, Currency: currency, Debit: transfer.Amount},
+			{Account: glFxConversion, Currency: currency, Credit: transfer.Amount},
+			{Account: glFxConversion, Currency: transfer.CreditCurrency, Debit: transfer.Credited},
+			{Account: glAccountBalances, Party: transfer.To, Currency: transfer.CreditCurrency, Credit: transfer.Credited},
+		}
+	}
+	return postJournal(stub, entry)
+}
+
+// journalSupplyChange books money minted into or burnt from an account. Conversions
+// change the supply too but are booked with their transfer.
+func journalSupplyChange(stub shim.ChaincodeStubInterface, change SupplyChange) error {
+	entry := JournalEntry{Source: change.Type, Reference: change.ID, Memo: change.Type + " by " + change.Admin}
+	issued := JournalLine{Account: glIssuedFunds, Currency: change.Currency}
+	balance := JournalLine{Account: glAccountBalances, Party: change.Account, Currency: change.Currency}
+	if change.Type == supplyMint {
+		issued.Debit = change.Amount
+		balance.Credit = change.Amount
+	} else if change.Type == supplyBurn {
+		issued.Credit = change.Amount
+		balance.Debit = change.Amount
+	} else {
+		return nil
+	}
+	entry.Lines = []JournalLine{issued, balance}
+	return postJournal(stub, entry)
+}
+
+//===========================start============journal queries=================================================
+// trialBalance totals the debits and credits posted to each ledger account in a currency,
+// and reconciles each account's balance against what the journal booked to it
+// args: [currency]
+func trialBalance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting optional currency")
+	}
+	currency, err := currencyArg(stub, args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := map[string]*TrialBalanceLine{}
+	booked := map[string]float64{}
+	err = scanPrefix(stub, journalPrefix, func(key string, value []byte) error {
+		var entry JournalEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			return errors.New("Error unmarshalling journal entry " + key)
+		}
+		for _, line := range entry.Lines {
+			if line.Currency != currency {
+				continue
+			}
+			total, ok := lines[line.Account]
+			if !ok {
+				total = &TrialBalanceLine{Account: line.Account, Name: chartOfAccounts[line.Account], Currency: currency}
+				lines[line.Account] = total
+			}
+			total.Debit += line.Debit
+			total.Credit += line.Credit
+			if line.Account == glAccountBalances {
+				booked[line.Party] += line.Credit - line.Debit
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+	for code := range lines {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	result := TrialBalance{Currency: currency, Lines: []TrialBalanceLine{}}
+	for _, code := range codes {
+		line := lines[code]
+		line.Balance = line.Debit - line.Credit
+		result.TotalDebit += line.Debit
+		result.TotalCredit += line.Credit
+		result.Lines = append(result.Lines, *line)
+	}
+	result.Balanced = math.Abs(result.TotalDebit-result.TotalCredit) < 0.005
+	if !result.Balanced {
+		fmt.Println("===================Trial balance in " + currency + " is out by " + strconv.FormatFloat(result.TotalDebit-result.TotalCredit, 'f', 2, 64))
+	}
+
+	// Parties the journal booked to but that have no account are left in booked afterwards
+	result.Differences = []BalanceDifference{}
+	err = scanPrefix(stub, accountPrefix, func(key string, value []byte) error {
+		var account Account
+		err := json.Unmarshal(value, &account)
+		if err != nil {
+			return errors.New("Error unmarshalling account " + key)
+		}
+		balance := balanceIn(account, currency)
+		journaled := booked[account.ID]
+		delete(booked, account.ID)
+		if math.Abs(balance-journaled) >= 0.005 {
+			result.Differences = append(result.Differences, BalanceDifference{Party: account.ID, Balance: balance, Journal: journaled, Difference: balance - journaled})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	parties := []string{}
+	for party := range booked {
+		parties = append(parties, party)
+	}
+	sort.Strings(parties)
+	for _, party := range parties {
+		if math.Abs(booked[party]) >= 0.005 {
+			result.Differences = append(result.Differences, BalanceDifference{Party: party, Journal: booked[party], Difference: -booked[party]})
+		}
+	}
+	result.Reconciled = len(result.Differences) == 0
+	if !result.Reconciled {
+		fmt.Println("===================Trial balance in " + currency + " found " + strconv.Itoa(len(result.Differences)) + " accounts out of line with the journal")
+	}
+	return json.Marshal(result)
+}
+
+// journal lists the entries posted between two times, optionally only those touching one account
+// args: from (ms), to (ms)[, account]
+func journal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from time, to time and optional account")
+	}
+
+	fromMs, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid from time " + args[0])
+	}
+	toMs, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid to time " + args[1])
+	}
+
+	entries := []JournalEntry{}
+	err = scanPrefix(stub, journalPrefix, func(key string, value []byte) error {
+		var entry JournalEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			return errors.New("Error unmarshalling journal entry " + key)
+		}
+		if entry.Time < fromMs || entry.Time >= toMs {
+			return nil
+		}
+		if len(args) == 3 {
+			touches := false
+			for _, line := range entry.Lines {
+				if line.Party == args[2] {
+					touches = true
+				}
+			}
+			if !touches {
+				return nil
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(entries)
+}
+
+//===========================end============journal queries=================================================
@@ -0,0 +1,71 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func trialBalanceOf(s *testStub) TrialBalance {
+	s.t.Helper()
+	var balance TrialBalance
+	err := json.Unmarshal(s.query("trialBalance"), &balance)
+	if err != nil {
+		s.t.Fatalf("trialBalance: %v", err)
+	}
+	return balance
+}
+
+func TestTransferPostsBalancedJournal(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("acme", "transaction", "acme", "relief", "50", "gift")
+
+	var entries []JournalEntry
+	err := json.Unmarshal(s.query("journal", "0", "99999999", "relief"), &entries)
+	if err != nil {
+		t.Fatalf("journal: %v", err)
+	}
+	if len(entries) != 1 || len(entries[0].Lines) != 2 {
+		t.Errorf("gift to relief posted %+v, want one two-line entry", entries)
+	}
+	balance := trialBalanceOf(s)
+	if !balance.Balanced || !balance.Reconciled {
+		t.Errorf("trial balance %+v does not balance against the accounts", balance)
+	}
+}
+
+func TestTrialBalanceFlagsUnpostedChange(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("acme", "transaction", "acme", "relief", "10", "gift")
+
+	relief := s.account("relief")
+	relief.CashBalance = 15
+	s.State[accountPrefix+"relief"], _ = json.Marshal(relief)
+	balance := trialBalanceOf(s)
+	if balance.Reconciled || len(balance.Differences) != 1 || balance.Differences[0].Difference != 5 {
+		t.Errorf("trial balance %+v missed 5 credited outside the journal", balance)
+	}
+	if _, err := s.cc.Query(s, "journal", []string{"yesterday", "today"}); err == nil {
+		t.Errorf("journal accepted times that are not numbers")
+	}
+}
@@ -0,0 +1,350 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========spending limit records===============
+var limitPrefix = "limit:"
+var limitOverridePrefix = "limitoverride:"
+var recentSpendPrefix = "recentspend:"
+
+// spendWindow is how far back payments count towards the daily limits (24h, in ms). It rolls
+// with each payment rather than resetting at midnight, so no 24 hours pay more than a day's limit.
+const spendWindow int64 = 24 * 60 * 60 * 1000
+
+// SpendingLimit caps what an account pays out, in the base currency; payments in other
+// currencies count at their value in it. Zero means no limit. Limits set on an account
+// replace the limits of its account type.
+type SpendingLimit struct {
+	MaxPerTransfer float64 `json:"maxPerTransfer"`
+	MaxPerDay      float64 `json:"maxPerDay"`
+	MaxCountPerDay int     `json:"maxCountPerDay"`
+}
+
+// DailySpend totals an account's outgoing transfers over the spendWindow up to a time
+type DailySpend struct {
+	Amount float64 `json:"amount"`
+	Count  int     `json:"count"`
+}
+
+type SpendingStatus struct {
+	Limit         *SpendingLimit `json:"limit"`
+	Since         int64          `json:"since"`
+	Spent         DailySpend     `json:"spent"`
+	OverrideUntil int64          `json:"overrideUntil,omitempty"`
+}
+
+//============end==========spending limit records===============
+
+// getSpendingLimit returns the limit that applies to an account, or nil when it has none
+func getSpendingLimit(stub shim.ChaincodeStubInterface, account Account) (*SpendingLimit, error) {
+	for _, key := range []string{limitPrefix + "acct:" + account.ID, limitPrefix + "type:" + accountType(account)} {
+		limitBytes, err := stub.GetState(key)
+		if err != nil {
+			return nil, errors.New("Error reading spending limit of " + account.ID)
+		}
+		if len(limitBytes) == 0 {
+			continue
+		}
+		var limit SpendingLimit
+		err = json.Unmarshal(limitBytes, &limit)
+		if err != nil {
+			return nil, errors.New("Error unmarshalling spending limit " + key)
+		}
+		return &limit, nil
+	}
+	return nil, nil
+}
+
+// getRecentSpending returns an account's payments still inside the spendWindow at now
+func getRecentSpending(stub shim.ChaincodeStubInterface, accountID string, now int64) ([]RecentTransfer, error) {
+	var recent []RecentTransfer
+	recentBytes, err := stub.GetState(recentSpendPrefix + accountID)
+	if err != nil {
+		return nil, errors.New("Error reading recent spending of " + accountID)
+	}
+	if len(recentBytes) > 0 {
+		err = json.Unmarshal(recentBytes, &recent)
+		if err != nil {
+			return nil, errors.New("Error unmarshalling recent spending of " + accountID)
+		}
+	}
+	kept := []RecentTransfer{}
+	for _, previous := range recent {
+		if previous.Time > now-spendWindow && previous.Time <= now {
+			kept = append(kept, previous)
+		}
+	}
+	return kept, nil
+}
+
+// totalSpending values recent payments in the base currency, at the current rates
+func totalSpending(stub shim.ChaincodeStubInterface, recent []RecentTransfer) (DailySpend, error) {
+	spend := DailySpend{Count: len(recent)}
+	for _, previous := range recent {
+		currency := previous.Currency
+		if currency == "" {
+			currency = baseCurrency
+		}
+		value, err := convertAmount(stub, previous.Amount, currency, baseCurrency)
+		if err != nil {
+			return spend, err
+		}
+		spend.Amount += value
+	}
+	return spend, nil
+}
+
+func getLimitOverride(stub shim.ChaincodeStubInterface, accountID string) (int64, error) {
+	overrideBytes, err := stub.GetState(limitOverridePrefix + accountID)
+	if err != nil {
+		return 0, errors.New("Error reading limit override of " + accountID)
+	}
+	if len(overrideBytes) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(overrideBytes), 10, 64)
+}
+
+// checkSpendingLimits checks a payment against the payer's limits and what it paid in the last
+// 24 hours. Payments in other currencies are only valued in the base currency when an amount
+// limit needs it. Accounts with an admin override in force skip the checks.
+func checkSpendingLimits(stub shim.ChaincodeStubInterface, fromID string, amount float64, currency string) error {
+	account, err := GetCompany(fromID, stub)
+	if err != nil {
+		return err
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return err
+	}
+	limit, err := getSpendingLimit(stub, account)
+	if err != nil || limit == nil {
+		return err
+	}
+	overrideUntil, err := getLimitOverride(stub, fromID)
+	if err != nil || now < overrideUntil {
+		return err
+	}
+
+	recent, err := getRecentSpending(stub, fromID, now)
+	if err != nil {
+		return err
+	}
+	spend := DailySpend{Count: len(recent)}
+	if limit.MaxPerTransfer > 0 || limit.MaxPerDay > 0 {
+		amount, err = convertAmount(stub, amount, currency, baseCurrency)
+		if err != nil {
+			return err
+		}
+	}
+	if limit.MaxPerDay > 0 {
+		spend, err = totalSpending(stub, recent)
+		if err != nil {
+			return err
+		}
+	}
+
+	amountStr := strconv.FormatFloat(amount, 'f', 2, 64)
+	if limit.MaxPerTransfer > 0 && amount > limit.MaxPerTransfer {
+		fmt.Println("===================Transfer of " + amountStr + " is over the limit for " + fromID)
+		return errors.New("Transfer of " + amountStr + " exceeds the per-transfer limit of " + strconv.FormatFloat(limit.MaxPerTransfer, 'f', 2, 64) + " for " + fromID)
+	}
+	if limit.MaxPerDay > 0 && spend.Amount+amount > limit.MaxPerDay {
+		fmt.Println("===================Transfer of " + amountStr + " is over the daily limit for " + fromID)
+		return errors.New("Transfer of " + amountStr + " exceeds the daily limit of " + strconv.FormatFloat(limit.MaxPerDay, 'f', 2, 64) + " for " + fromID + ", " + strconv.FormatFloat(spend.Amount, 'f', 2, 64) + " already spent in the last 24 hours")
+	}
+	if limit.MaxCountPerDay > 0 && spend.Count+1 > limit.MaxCountPerDay {
+		fmt.Println("===================Too many transfers in the last 24 hours for " + fromID)
+		return errors.New("Transfer exceeds the limit of " + strconv.Itoa(limit.MaxCountPerDay) + " transfers per day for " + fromID)
+	}
+
+	return nil
+}
+
+// recordSpending counts a completed payment towards the payer's totals for the last 24 hours
+func recordSpending(stub shim.ChaincodeStubInterface, fromID string, amount float64, currency string) error {
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return err
+	}
+	recent, err := getRecentSpending(stub, fromID, now)
+	if err != nil {
+		return err
+	}
+
+	if currency == baseCurrency {
+		currency = ""
+	}
+	recent = append(recent, RecentTransfer{Time: now, Amount: amount, Currency: currency})
+	recentBytes, err := json.Marshal(recent)
+	if err != nil {
+		return errors.New("Error marshalling recent spending of " + fromID)
+	}
+	err = stub.PutState(recentSpendPrefix+fromID, recentBytes)
+	if err != nil {
+		return errors.New("Error writing recent spending of " + fromID)
+	}
+	return nil
+}
+
+//===========================start============spending limit functions=================================================
+// setSpendingLimit sets the limits of an account type or a single account. All zero values
+// remove the limit.
+// args: admin, scope (TYPE or ACCOUNT), account type or account id, max per transfer, max per day, max count per day
+func (t *SimpleChaincode) setSpendingLimit(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting spending limit.=========================")
+
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, scope, target, max per transfer, max per day and max count per day")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set spending limit for " + args[0])
+	}
+
+	var key string
+	if args[1] == "TYPE" {
+		if args[2] != "ADMIN" && args[2] != "CORPORATE" && args[2] != "NGO" && args[2] != "VENDOR" {
+			return nil, errors.New("Invalid account type")
+		}
+		key = limitPrefix + "type:" + args[2]
+	} else if args[1] == "ACCOUNT" {
+		_, err = GetCompany(args[2], stub)
+		if err != nil {
+			return nil, err
+		}
+		key = limitPrefix + "acct:" + args[2]
+	} else {
+		return nil, errors.New("Invalid limit scope " + args[1])
+	}
+
+	var limit SpendingLimit
+	limit.MaxPerTransfer, err = strconv.ParseFloat(args[3], 64)
+	if err != nil || limit.MaxPerTransfer < 0 {
+		return nil, errors.New("Invalid max per transfer " + args[3])
+	}
+	limit.MaxPerDay, err = strconv.ParseFloat(args[4], 64)
+	if err != nil || limit.MaxPerDay < 0 {
+		return nil, errors.New("Invalid max per day " + args[4])
+	}
+	limit.MaxCountPerDay, err = strconv.Atoi(args[5])
+	if err != nil || limit.MaxCountPerDay < 0 {
+		return nil, errors.New("Invalid max count per day " + args[5])
+	}
+
+	if limit == (SpendingLimit{}) {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, errors.New("Error removing spending limit " + key)
+		}
+		return nil, nil
+	}
+
+	limitBytes, err := json.Marshal(&limit)
+	if err != nil {
+		return nil, errors.New("Error marshalling spending limit " + key)
+	}
+	err = stub.PutState(key, limitBytes)
+	if err != nil {
+		return nil, errors.New("Error writing spending limit " + key)
+	}
+	return nil, nil
+}
+
+// grantLimitOverride lets an account pay past its spending limits until the given time
+// args: admin, account, until (ms)
+func (t *SimpleChaincode) grantLimitOverride(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Granting limit override.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, account and until")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to override limits for " + args[0])
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+	until, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid until " + args[2])
+	}
+
+	err = stub.PutState(limitOverridePrefix+args[1], []byte(strconv.FormatInt(until, 10)))
+	if err != nil {
+		return nil, errors.New("Error writing limit override of " + args[1])
+	}
+	fmt.Println("==================***=== Limits overridden for " + args[1] + " by " + args[0] + " ====***====================")
+	return nil, nil
+}
+
+//===========================end============spending limit functions=================================================
+
+//===========================start============spending limit queries=================================================
+// getSpendingStatus returns the limit that applies to an account and what it spent in the 24
+// hours up to the given time
+// args: account, as of (ms)
+func getSpendingStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account and as of time")
+	}
+
+	asOf, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid as of time " + args[1])
+	}
+	account, err := GetCompany(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	status := SpendingStatus{Since: asOf - spendWindow}
+	status.Limit, err = getSpendingLimit(stub, account)
+	if err != nil {
+		return nil, err
+	}
+	recent, err := getRecentSpending(stub, account.ID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	status.Spent, err = totalSpending(stub, recent)
+	if err != nil {
+		return nil, err
+	}
+	status.OverrideUntil, err = getLimitOverride(stub, account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(status)
+}
+
+//===========================end============spending limit queries=================================================
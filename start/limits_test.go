@@ -0,0 +1,50 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestSpendingLimitWindowRolls(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "1000")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "setSpendingLimit", "root", "TYPE", "CORPORATE", "60", "100", "0")
+
+	s.mustInvoke("acme", "transaction", "acme", "relief", "60", "gift")
+	s.now += 24 * 60 * 60 * 1000
+	s.mustInvoke("acme", "transaction", "acme", "relief", "60", "gift")
+	if s.balance("relief") != 120 {
+		t.Errorf("relief received %v across two days, want 120", s.balance("relief"))
+	}
+}
+
+func TestSpendingLimitRefusesOverLimit(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "1000")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "setSpendingLimit", "root", "TYPE", "CORPORATE", "60", "100", "0")
+	s.mustFail("acme", "setSpendingLimit", "acme", "ACCOUNT", "acme", "0", "0", "0")
+
+	s.mustFail("acme", "transaction", "acme", "relief", "70", "gift")
+	s.mustInvoke("acme", "transaction", "acme", "relief", "60", "gift")
+	s.now += 12 * 60 * 60 * 1000
+	s.mustFail("acme", "transaction", "acme", "relief", "60", "gift")
+	if s.balance("relief") != 60 {
+		t.Errorf("relief received %v over a 100 daily limit", s.balance("relief"))
+	}
+}
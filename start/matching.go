@@ -0,0 +1,449 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========matching program records===============
+var matchingProgramPrefix = "matchprog:"
+var matchedTotalPrefix = "matched:"
+
+// matchIndexPrefix lists the matches paid on a donation, as donation:match transfer ids
+// pointing to the program that paid each one
+var matchIndexPrefix = "matchidx:"
+
+const (
+	matchingActive = "ACTIVE"
+	matchingClosed = "CLOSED"
+)
+
+// MatchingProgram describes how a corporate matches donations into NGO accounts.
+// Empty EligibleNGOs or EligibleDonors lists mean any NGO or any donor.
+type MatchingProgram struct {
+	ID             string   `json:"id"`
+	Corporate      string   `json:"corporate"`
+	Ratio          float64  `json:"ratio"`
+	PerDonorCap    float64  `json:"perDonorCap"`
+	Budget         float64  `json:"budget"`
+	Spent          float64  `json:"spent"`
+	EligibleNGOs   []string `json:"eligibleNGOs,omitempty"`
+	EligibleDonors []string `json:"eligibleDonors,omitempty"`
+	Start          int64    `json:"start"`
+	End            int64    `json:"end"`
+	Status         string   `json:"status"`
+}
+
+type MatchingTotals struct {
+	Program MatchingProgram    `json:"program"`
+	Donors  map[string]float64 `json:"donors"`
+}
+
+//============end==========matching program records===============
+
+func getMatchingProgram(stub shim.ChaincodeStubInterface, programID string) (MatchingProgram, error) {
+	var program MatchingProgram
+	programBytes, err := stub.GetState(matchingProgramPrefix + programID)
+	if err != nil || len(programBytes) == 0 {
+		fmt.Println("Matching program not found " + programID)
+		return program, errors.New("Matching program not found " + programID)
+	}
+
+	err = json.Unmarshal(programBytes, &program)
+	if err != nil {
+		fmt.Println("Error unmarshalling matching program " + programID + "\n err:" + err.Error())
+		return program, errors.New("Error unmarshalling matching program " + programID)
+	}
+	return program, nil
+}
+
+func putMatchingProgram(stub shim.ChaincodeStubInterface, program MatchingProgram) error {
+	programBytes, err := json.Marshal(&program)
+	if err != nil {
+		fmt.Println("Error marshalling matching program " + program.ID)
+		return errors.New("Error marshalling matching program " + program.ID)
+	}
+	err = stub.PutState(matchingProgramPrefix+program.ID, programBytes)
+	if err != nil {
+		fmt.Println("Error writing matching program " + program.ID)
+		return errors.New("Error writing matching program " + program.ID)
+	}
+	return nil
+}
+
+func getMatchedTotal(stub shim.ChaincodeStubInterface, programID string, donor string) (float64, error) {
+	totalBytes, err := stub.GetState(matchedTotalPrefix + programID + ":" + donor)
+	if err != nil {
+		return 0, errors.New("Error reading matched total of " + donor)
+	}
+	if len(totalBytes) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseFloat(string(totalBytes), 64)
+}
+
+func listContains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// putMatchedTotal sets how much of a donor's donations a program has matched
+func putMatchedTotal(stub shim.ChaincodeStubInterface, programID string, donor string, total float64) error {
+	err := stub.PutState(matchedTotalPrefix+programID+":"+donor, []byte(strconv.FormatFloat(total, 'f', -1, 64)))
+	if err != nil {
+		return errors.New("Error writing matched total of " + donor)
+	}
+	return nil
+}
+
+// applyDonationMatching pays the matching share of every active program that covers a
+// donation into an NGO. Matches go through the same checks as any transfer out of the
+// corporate's account and get a receipt of their own. A program that can't pay, because its
+// budget or the corporate's cash ran out or the checks refuse the match, is skipped rather
+// than failing the donation.
+func applyDonationMatching(stub shim.ChaincodeStubInterface, donation Transfer) error {
+	donor := donation.From
+	ngo := donation.To
+	amount := donation.Amount
+
+	// Matches aren't matched again
+	if donation.MatchOf != "" {
+		return nil
+	}
+
+	// Matching budgets are in the base currency, so conversions match what the NGO was credited
+	if donation.CreditCurrency != "" {
+		if donation.CreditCurrency != baseCurrency {
+			return nil
+		}
+		amount = donation.Credited
+	} else if donation.Currency != "" {
+		return nil
+	}
+
+	recipient, err := GetCompany(ngo, stub)
+	if err != nil {
+		return err
+	}
+	if accountType(recipient) != "NGO" {
+		return nil
+	}
+
+	var programs []MatchingProgram
+	err = scanPrefix(stub, matchingProgramPrefix, func(key string, value []byte) error {
+		var program MatchingProgram
+		err := json.Unmarshal(value, &program)
+		if err != nil {
+			return errors.New("Error unmarshalling matching program " + key)
+		}
+		if program.Status == matchingActive && program.Corporate != donor &&
+			(len(program.EligibleNGOs) == 0 || listContains(program.EligibleNGOs, ngo)) &&
+			(len(program.EligibleDonors) == 0 || listContains(program.EligibleDonors, donor)) {
+			programs = append(programs, program)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(programs) == 0 {
+		return nil
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return err
+	}
+
+	for _, program := range programs {
+		if now < program.Start || now >= program.End {
+			continue
+		}
+		matched, err := getMatchedTotal(stub, program.ID, donor)
+		if err != nil {
+			return err
+		}
+
+		match := math.Min(amount*program.Ratio, math.Min(program.PerDonorCap-matched, program.Budget-program.Spent))
+		if match < 0.005 {
+			continue
+		}
+		match = math.Floor(match*100) / 100
+
+		// A multisig corporate's signers haven't approved the match, so it isn't paid
+		multisig, err := getMultisig(stub, program.Corporate)
+		if err != nil {
+			return err
+		}
+		if multisig != nil {
+			fmt.Println("===================Matching program " + program.ID + " could not match: " + program.Corporate + " is a multisig account")
+			continue
+		}
+		memo := "Match of " + donation.ID + " by " + program.ID
+		checks, err := checkTransfer(stub, program.Corporate, ngo, match, baseCurrency, memo)
+		if block, ok := err.(*screeningBlock); ok {
+			_, err = block.record(stub, "MATCH")
+			if err != nil {
+				return err
+			}
+			fmt.Println("===================Matching program " + program.ID + " could not match: " + block.Error())
+			continue
+		}
+		// A match the aml rules would hold isn't made: clearing it later would pay it outside
+		// the program's budget
+		if err != nil {
+			fmt.Println("===================Matching program " + program.ID + " could not match: " + err.Error())
+			continue
+		}
+		transfer, err := moveFunds(stub, Transfer{From: program.Corporate, To: ngo, Amount: match, Memo: memo, MatchOf: donation.ID,
+			Fee: checks.Fee.Fee, FeeCollector: checks.Fee.Collector})
+		if err != nil {
+			fmt.Println("===================Matching program " + program.ID + " could not match: " + err.Error())
+			continue
+		}
+		err = completeTransfer(stub, transfer, checks)
+		if err != nil {
+			return err
+		}
+		err = issueDonationReceipt(stub, transfer)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(matchIndexPrefix+donation.ID+":"+transfer.ID, []byte(program.ID))
+		if err != nil {
+			return errors.New("Error indexing match " + transfer.ID)
+		}
+
+		program.Spent += match
+		err = putMatchingProgram(stub, program)
+		if err != nil {
+			return err
+		}
+		err = putMatchedTotal(stub, program.ID, donor, matched+match)
+		if err != nil {
+			return err
+		}
+		fmt.Println("==================***=== " + program.Corporate + " matched " + strconv.FormatFloat(match, 'f', 2, 64) + " for " + donor + " ====***====================")
+	}
+	return nil
+}
+
+// reverseDonationMatching takes back the matches paid on a donation in proportion to a refund
+// of it, all that is left of them once the donation is refunded in full
+func reverseDonationMatching(stub shim.ChaincodeStubInterface, refund Transfer, donation Transfer) error {
+	matchIDs := []string{}
+	prefix := matchIndexPrefix + donation.ID + ":"
+	err := scanPrefix(stub, prefix, func(key string, value []byte) error {
+		matchIDs = append(matchIDs, key[len(prefix):])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	refundedInFull := donation.Refunded+refund.Amount > donation.Amount-0.005
+	for _, matchID := range matchIDs {
+		match, err := getTransfer(stub, matchID)
+		if err != nil {
+			return err
+		}
+		share := match.Amount - match.Refunded
+		if !refundedInFull {
+			share = math.Min(share, math.Floor(match.Amount*refund.Amount/donation.Amount*100)/100)
+		}
+		if share < 0.005 {
+			continue
+		}
+		_, err = refundTransfer(stub, match, share, "Reversal of "+match.ID+" on "+refund.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Println("==================***=== Match " + match.ID + " reversed by " + strconv.FormatFloat(share, 'f', 2, 64) + " ====***====================")
+	}
+	return nil
+}
+
+// restoreMatchBudget gives a program back the budget and donor allowance of a refunded match
+func restoreMatchBudget(stub shim.ChaincodeStubInterface, match Transfer, amount float64) error {
+	programID, err := stub.GetState(matchIndexPrefix + match.MatchOf + ":" + match.ID)
+	if err != nil || len(programID) == 0 {
+		return errors.New("Error reading the program of match " + match.ID)
+	}
+	program, err := getMatchingProgram(stub, string(programID))
+	if err != nil {
+		return err
+	}
+	donation, err := getTransfer(stub, match.MatchOf)
+	if err != nil {
+		return err
+	}
+	matched, err := getMatchedTotal(stub, program.ID, donation.From)
+	if err != nil {
+		return err
+	}
+
+	program.Spent = math.Max(program.Spent-amount, 0)
+	err = putMatchingProgram(stub, program)
+	if err != nil {
+		return err
+	}
+	return putMatchedTotal(stub, program.ID, donation.From, math.Max(matched-amount, 0))
+}
+
+//===========================start============matching program functions=================================================
+// createMatchingProgram sets up a corporate's donation matching
+// args: corporate, ratio, per donor cap, budget, eligible ngos (JSON array), eligible donors (JSON array), start (ms), end (ms)
+func (t *SimpleChaincode) createMatchingProgram(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Creating matching program.=========================")
+
+	if len(args) != 8 {
+		return nil, errors.New("Incorrect number of arguments. Expecting corporate, ratio, per donor cap, budget, eligible ngos, eligible donors, start and end")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "CORPORATE")
+	if err != nil {
+		return nil, err
+	}
+
+	var values [3]float64
+	for i := range values {
+		values[i], err = strconv.ParseFloat(args[i+1], 64)
+		if err != nil || values[i] <= 0 {
+			return nil, errors.New("Invalid matching value " + args[i+1])
+		}
+	}
+
+	var eligibleNGOs, eligibleDonors []string
+	if args[4] != "" {
+		err = json.Unmarshal([]byte(args[4]), &eligibleNGOs)
+		if err != nil {
+			return nil, errors.New("Invalid eligible ngos " + args[4])
+		}
+		for _, ngo := range eligibleNGOs {
+			_, err = getAccountOfType(stub, ngo, "NGO")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if args[5] != "" {
+		err = json.Unmarshal([]byte(args[5]), &eligibleDonors)
+		if err != nil {
+			return nil, errors.New("Invalid eligible donors " + args[5])
+		}
+	}
+
+	start, err := strconv.ParseInt(args[6], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid start " + args[6])
+	}
+	end, err := strconv.ParseInt(args[7], 10, 64)
+	if err != nil || end <= start {
+		return nil, errors.New("Invalid end " + args[7])
+	}
+
+	programID, err := nextID(stub, "MTC")
+	if err != nil {
+		return nil, err
+	}
+	program := MatchingProgram{
+		ID:             programID,
+		Corporate:      args[0],
+		Ratio:          values[0],
+		PerDonorCap:    values[1],
+		Budget:         values[2],
+		EligibleNGOs:   eligibleNGOs,
+		EligibleDonors: eligibleDonors,
+		Start:          start,
+		End:            end,
+		Status:         matchingActive,
+	}
+	err = putMatchingProgram(stub, program)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Matching program " + programID + " created ====***====================")
+	return []byte(programID), nil
+}
+
+// closeMatchingProgram stops a program from matching any further donations
+// args: corporate, program id
+func (t *SimpleChaincode) closeMatchingProgram(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Closing matching program.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting corporate and program id")
+	}
+
+	program, err := getMatchingProgram(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if program.Corporate != args[0] {
+		return nil, errors.New("Matching program " + program.ID + " does not belong to " + args[0])
+	}
+
+	program.Status = matchingClosed
+	return nil, putMatchingProgram(stub, program)
+}
+
+//===========================end============matching program functions=================================================
+
+//===========================start============matching program queries=================================================
+// getMatchingTotals returns a program with the amount matched so far for each donor
+// args: program id
+func getMatchingTotals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting program id")
+	}
+
+	program, err := getMatchingProgram(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	totals := MatchingTotals{Program: program, Donors: map[string]float64{}}
+	prefix := matchedTotalPrefix + program.ID + ":"
+	err = scanPrefix(stub, prefix, func(key string, value []byte) error {
+		total, err := strconv.ParseFloat(string(value), 64)
+		if err != nil {
+			return errors.New("Error reading matched total " + key)
+		}
+		totals.Donors[key[len(prefix):]] = total
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(totals)
+}
+
+//===========================end============matching program queries=================================================
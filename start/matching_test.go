@@ -0,0 +1,56 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestMatchEligibleDonation(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "1000")
+	s.openAccount("staff", "CORPORATE", "1000")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("acme", "createMatchingProgram", "acme", "2", "150", "1000", `["relief"]`, `["staff"]`, "0", "9000000")
+
+	s.mustInvoke("staff", "transaction", "staff", "relief", "50", "gift")
+	s.mustInvoke("staff", "transaction", "staff", "relief", "50", "gift")
+	if s.balance("relief") != 250 || s.balance("acme") != 850 {
+		t.Errorf("relief received %v for 100 matched 2:1 up to 150, want 250", s.balance("relief"))
+	}
+
+	id := string(s.mustInvoke("staff", "transaction", "staff", "relief", "10", "gift"))
+	s.mustInvoke("relief", "refund", "relief", id, "10")
+	if s.balance("acme") != 850 {
+		t.Errorf("acme paid %v beyond the donor cap", 850-s.balance("acme"))
+	}
+}
+
+func TestMatchRefusesIneligibleDonation(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "1000")
+	s.openAccount("staff", "CORPORATE", "1000")
+	s.openAccount("outsider", "CORPORATE", "1000")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("acme", "createMatchingProgram", "acme", "1", "150", "1000", `[]`, `["staff"]`, "0", "9000000")
+	s.mustInvoke("root", "setSpendingLimit", "root", "ACCOUNT", "acme", "20", "0", "0")
+
+	s.mustInvoke("outsider", "transaction", "outsider", "relief", "50", "gift")
+	s.mustInvoke("staff", "transaction", "staff", "relief", "50", "gift")
+	if s.balance("relief") != 100 || s.balance("acme") != 1000 {
+		t.Errorf("acme matched %v despite an ineligible donor and its own spending limit", 1000-s.balance("acme"))
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// testStub is a MockStub with the transaction clock and caller certificate the mock leaves
+// out. Each invoke runs as its own transaction a minute after the last.
+type testStub struct {
+	*shim.MockStub
+	t      *testing.T
+	cc     *SimpleChaincode
+	caller string
+	now    int64
+	txs    int
+}
+
+// newTestStub starts a ledger with root as its only admin
+func newTestStub(t *testing.T) *testStub {
+	cc := new(SimpleChaincode)
+	s := &testStub{MockStub: shim.NewMockStub("test", cc), t: t, cc: cc, now: 1000000}
+	s.transaction(func() ([]byte, error) {
+		return cc.Init(s, "init", []string{"root"})
+	})
+	return s
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.now / millisPerSecond, Nanos: int32(s.now%millisPerSecond) * int32(nanosPerMillisecond)}, nil
+}
+
+func (s *testStub) ReadCertAttribute(name string) ([]byte, error) {
+	if name != callerAttribute || s.caller == "" {
+		return nil, nil
+	}
+	return []byte(s.caller), nil
+}
+
+func (s *testStub) transaction(fn func() ([]byte, error)) ([]byte, error) {
+	s.txs++
+	s.now += 60000
+	txID := "tx" + strconv.Itoa(s.txs)
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+	return fn()
+}
+
+// invoke runs function as caller
+func (s *testStub) invoke(caller string, function string, args ...string) ([]byte, error) {
+	s.caller = caller
+	return s.transaction(func() ([]byte, error) {
+		return s.cc.Invoke(s, function, args)
+	})
+}
+
+func (s *testStub) mustInvoke(caller string, function string, args ...string) []byte {
+	s.t.Helper()
+	result, err := s.invoke(caller, function, args...)
+	if err != nil {
+		s.t.Fatalf("%s %v: %v", function, args, err)
+	}
+	return result
+}
+
+func (s *testStub) mustFail(caller string, function string, args ...string) error {
+	s.t.Helper()
+	_, err := s.invoke(caller, function, args...)
+	if err == nil {
+		s.t.Fatalf("%s %v was accepted", function, args)
+	}
+	return err
+}
+
+func (s *testStub) query(function string, args ...string) []byte {
+	s.t.Helper()
+	result, err := s.cc.Query(s, function, args)
+	if err != nil {
+		s.t.Fatalf("query %s %v: %v", function, args, err)
+	}
+	return result
+}
+
+// openAccount creates an account. Root mints any opening balance and signs off admin accounts;
+// anyone else opens their own.
+func (s *testStub) openAccount(id string, accountType string, amount string) {
+	s.t.Helper()
+	if amount == "0" && accountType != "ADMIN" {
+		s.mustInvoke(id, "createAccount", id, accountType, amount)
+		return
+	}
+	s.mustInvoke("root", "createAccount", id, accountType, amount, "root")
+}
+
+func (s *testStub) account(id string) Account {
+	s.t.Helper()
+	var account Account
+	err := json.Unmarshal(s.State[accountPrefix+id], &account)
+	if err != nil {
+		s.t.Fatalf("account %s: %v", id, err)
+	}
+	return account
+}
+
+func (s *testStub) balance(id string) float64 {
+	s.t.Helper()
+	return s.account(id).CashBalance
+}
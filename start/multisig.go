@@ -0,0 +1,381 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========multisig records===============
+var multisigPrefix = "multisig:"
+var pendingSpendPrefix = "mspend:"
+
+const (
+	spendPending   = "PENDING"
+	spendExecuted  = "EXECUTED"
+	spendCancelled = "CANCELLED"
+)
+
+// Multisig makes transactions from Account wait for Threshold distinct signers, and refuses
+// every other way of paying out of it. Spends that aren't approved within TTL milliseconds expire.
+type Multisig struct {
+	Account   string   `json:"account"`
+	Signers   []string `json:"signers"`
+	Threshold int      `json:"threshold"`
+	TTL       int64    `json:"ttl"`
+}
+
+// PendingSpend is a transaction from a multisig account waiting for its signers. Nothing is
+// held, so the account's balance is checked when the spend executes.
+type PendingSpend struct {
+	ID        string   `json:"id"`
+	Account   string   `json:"account"`
+	To        string   `json:"to"`
+	Amount    float64  `json:"amount"`
+	Currency  string   `json:"currency"`
+	Memo      string   `json:"memo,omitempty"`
+	Threshold int      `json:"threshold"`
+	Approvals []string `json:"approvals"`
+	Status    string   `json:"status"`
+	Transfer  string   `json:"transfer,omitempty"`
+	CreatedAt int64    `json:"createdAt"`
+	ExpiresAt int64    `json:"expiresAt"`
+	ClosedAt  int64    `json:"closedAt,omitempty"`
+	ClosedBy  string   `json:"closedBy,omitempty"`
+}
+
+//============end==========multisig records===============
+
+// getMultisig returns an account's multisig settings, or nil when it spends on its own
+func getMultisig(stub shim.ChaincodeStubInterface, accountID string) (*Multisig, error) {
+	multisigBytes, err := stub.GetState(multisigPrefix + accountID)
+	if err != nil {
+		return nil, errors.New("Error reading multisig settings of " + accountID)
+	}
+	if len(multisigBytes) == 0 {
+		return nil, nil
+	}
+	var multisig Multisig
+	err = json.Unmarshal(multisigBytes, &multisig)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling multisig settings of " + accountID)
+	}
+	return &multisig, nil
+}
+
+// checkSingleSigner refuses to debit a multisig account other than through a spend its
+// signers approved
+func checkSingleSigner(stub shim.ChaincodeStubInterface, accountID string) error {
+	multisig, err := getMultisig(stub, accountID)
+	if err != nil {
+		return err
+	}
+	if multisig != nil {
+		fmt.Println("===================" + accountID + " needs its signers to approve payments")
+		return errors.New("Account " + accountID + " needs " + strconv.Itoa(multisig.Threshold) + " signers to approve its payments, pay with transaction instead")
+	}
+	return nil
+}
+
+func getPendingSpend(stub shim.ChaincodeStubInterface, spendID string) (PendingSpend, error) {
+	var spend PendingSpend
+	spendBytes, err := stub.GetState(pendingSpendPrefix + spendID)
+	if err != nil || len(spendBytes) == 0 {
+		fmt.Println("Pending spend not found " + spendID)
+		return spend, errors.New("Pending spend not found " + spendID)
+	}
+
+	err = json.Unmarshal(spendBytes, &spend)
+	if err != nil {
+		fmt.Println("Error unmarshalling pending spend " + spendID + "\n err:" + err.Error())
+		return spend, errors.New("Error unmarshalling pending spend " + spendID)
+	}
+	return spend, nil
+}
+
+func putPendingSpend(stub shim.ChaincodeStubInterface, spend PendingSpend) error {
+	spendBytes, err := json.Marshal(&spend)
+	if err != nil {
+		fmt.Println("Error marshalling pending spend " + spend.ID)
+		return errors.New("Error marshalling pending spend " + spend.ID)
+	}
+	err = stub.PutState(pendingSpendPrefix+spend.ID, spendBytes)
+	if err != nil {
+		fmt.Println("Error writing pending spend " + spend.ID)
+		return errors.New("Error writing pending spend " + spend.ID)
+	}
+	return nil
+}
+
+// createPendingSpend records a transaction from a multisig account for its signers to approve
+func createPendingSpend(stub shim.ChaincodeStubInterface, multisig Multisig, toID string, amount float64, currency string, memo string) ([]byte, error) {
+	if toID == multisig.Account {
+		return nil, errors.New("Cannot transfer from " + toID + " to itself")
+	}
+	if amount <= 0.0 {
+		return nil, errors.New("Invalid Amount value")
+	}
+	_, err := GetCompany(toID, stub)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	spendID, err := nextID(stub, "MSP")
+	if err != nil {
+		return nil, err
+	}
+	spend := PendingSpend{
+		ID:        spendID,
+		Account:   multisig.Account,
+		To:        toID,
+		Amount:    amount,
+		Currency:  currency,
+		Memo:      memo,
+		Threshold: multisig.Threshold,
+		Approvals: []string{},
+		Status:    spendPending,
+		CreatedAt: now,
+		ExpiresAt: now + multisig.TTL,
+	}
+	err = putPendingSpend(stub, spend)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Spend " + spendID + " from " + multisig.Account + " awaiting signers ====***====================")
+	return []byte(spendID), nil
+}
+
+//===========================start============multisig functions=================================================
+// setMultisig makes an account's transactions wait for a threshold of its signers. A zero
+// threshold turns multisig off again.
+// args: admin, account, signers (JSON array), threshold, spend ttl (ms)
+func (t *SimpleChaincode) setMultisig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting multisig.=========================")
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, account, signers, threshold and spend ttl")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set multisig for " + args[0])
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold, err := strconv.Atoi(args[3])
+	if err != nil || threshold < 0 {
+		return nil, errors.New("Invalid threshold " + args[3])
+	}
+	if threshold == 0 {
+		err = stub.DelState(multisigPrefix + args[1])
+		if err != nil {
+			return nil, errors.New("Error removing multisig settings of " + args[1])
+		}
+		return nil, nil
+	}
+
+	var signers []string
+	err = json.Unmarshal([]byte(args[2]), &signers)
+	if err != nil {
+		return nil, errors.New("Invalid signers " + args[2])
+	}
+	for i, signer := range signers {
+		if signer == args[1] {
+			return nil, errors.New("An account can't sign its own spends")
+		}
+		if listContains(signers[:i], signer) {
+			return nil, errors.New("Signer " + signer + " is listed twice")
+		}
+		_, err = GetCompany(signer, stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if threshold > len(signers) {
+		return nil, errors.New("Threshold " + args[3] + " is more than the number of signers")
+	}
+	ttl, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || ttl <= 0 {
+		return nil, errors.New("Invalid spend ttl " + args[4])
+	}
+
+	multisig := Multisig{Account: args[1], Signers: signers, Threshold: threshold, TTL: ttl}
+	multisigBytes, err := json.Marshal(&multisig)
+	if err != nil {
+		return nil, errors.New("Error marshalling multisig settings of " + args[1])
+	}
+	err = stub.PutState(multisigPrefix+args[1], multisigBytes)
+	if err != nil {
+		return nil, errors.New("Error writing multisig settings of " + args[1])
+	}
+	return nil, nil
+}
+
+// approveSpend adds a signer's approval and executes the spend once it has enough. The signer
+// has to be the caller.
+// args: signer, spend id
+func (t *SimpleChaincode) approveSpend(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Approving spend.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting signer and spend id")
+	}
+
+	err := checkCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	spend, err := getPendingSpend(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if spend.Status != spendPending {
+		return nil, errors.New("Spend " + spend.ID + " is " + spend.Status)
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now >= spend.ExpiresAt {
+		return nil, errors.New("Spend " + spend.ID + " has expired")
+	}
+
+	// Signers are checked against the current settings, so removed signers can't approve
+	multisig, err := getMultisig(stub, spend.Account)
+	if err != nil {
+		return nil, err
+	}
+	if multisig == nil || !listContains(multisig.Signers, args[0]) {
+		fmt.Println("===================" + args[0] + " is not a signer for " + spend.Account)
+		return nil, errors.New(args[0] + " is not a signer for " + spend.Account)
+	}
+	if listContains(spend.Approvals, args[0]) {
+		return nil, errors.New("Spend " + spend.ID + " is already approved by " + args[0])
+	}
+
+	spend.Approvals = append(spend.Approvals, args[0])
+	if len(spend.Approvals) >= spend.Threshold {
+		spend.Transfer, err = executeTransfer(stub, spend.Account, spend.To, spend.Amount, spend.Currency, spend.Memo)
+		if err != nil {
+			return nil, err
+		}
+		spend.Status = spendExecuted
+		spend.ClosedAt = now
+		spend.ClosedBy = args[0]
+		fmt.Println("==================***=== Spend " + spend.ID + " executed as " + spend.Transfer + " ====***====================")
+	}
+
+	return []byte(spend.Transfer), putPendingSpend(stub, spend)
+}
+
+// cancelSpend withdraws a pending spend. Any signer of the account can cancel it as the caller.
+// args: signer, spend id
+func (t *SimpleChaincode) cancelSpend(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Cancelling spend.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting signer and spend id")
+	}
+
+	err := checkCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	spend, err := getPendingSpend(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if spend.Status != spendPending {
+		return nil, errors.New("Spend " + spend.ID + " is " + spend.Status)
+	}
+	multisig, err := getMultisig(stub, spend.Account)
+	if err != nil {
+		return nil, err
+	}
+	if multisig == nil || !listContains(multisig.Signers, args[0]) {
+		return nil, errors.New(args[0] + " is not a signer for " + spend.Account)
+	}
+
+	spend.ClosedAt, err = txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	spend.Status = spendCancelled
+	spend.ClosedBy = args[0]
+	return nil, putPendingSpend(stub, spend)
+}
+
+//===========================end============multisig functions=================================================
+
+//===========================start============multisig queries=================================================
+// getPendingSpends lists the spends from an account, or awaiting an account's signature,
+// that are still open at the given time
+// args: account, as of (ms)
+func getPendingSpends(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account and as of time")
+	}
+
+	asOf, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid as of time " + args[1])
+	}
+
+	spends := []PendingSpend{}
+	err = scanPrefix(stub, pendingSpendPrefix, func(key string, value []byte) error {
+		var spend PendingSpend
+		err := json.Unmarshal(value, &spend)
+		if err != nil {
+			return errors.New("Error unmarshalling pending spend " + key)
+		}
+		if spend.Status != spendPending || asOf >= spend.ExpiresAt {
+			return nil
+		}
+		if spend.Account != args[0] {
+			multisig, err := getMultisig(stub, spend.Account)
+			if err != nil {
+				return err
+			}
+			if multisig == nil || !listContains(multisig.Signers, args[0]) {
+				return nil
+			}
+		}
+		spends = append(spends, spend)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(spends)
+}
+
+//===========================end============multisig queries=================================================
@@ -0,0 +1,59 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestMultisigSpendNeedsThreshold(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "100")
+	s.openAccount("tentco", "VENDOR", "0")
+	s.openAccount("chair", "CORPORATE", "0")
+	s.openAccount("treasurer", "CORPORATE", "0")
+	s.openAccount("secretary", "CORPORATE", "0")
+	s.mustInvoke("root", "setMultisig", "root", "relief", `["chair","treasurer","secretary"]`, "2", "1000000")
+
+	id := string(s.mustInvoke("relief", "transaction", "relief", "tentco", "40", "tents"))
+	s.mustInvoke("chair", "approveSpend", "chair", id)
+	if s.balance("tentco") != 0 {
+		t.Fatalf("spend paid out after one of two signatures")
+	}
+	s.mustInvoke("secretary", "approveSpend", "secretary", id)
+	if s.balance("tentco") != 40 || s.balance("relief") != 60 {
+		t.Errorf("signed spend paid tentco %v, want 40", s.balance("tentco"))
+	}
+}
+
+func TestApproveSpendRefusesRepeatAndImpostorSigners(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "100")
+	s.openAccount("tentco", "VENDOR", "0")
+	s.openAccount("chair", "CORPORATE", "0")
+	s.openAccount("treasurer", "CORPORATE", "0")
+	s.mustFail("root", "setMultisig", "root", "relief", `["chair","treasurer"]`, "3", "1000000")
+	s.mustInvoke("root", "setMultisig", "root", "relief", `["chair","treasurer"]`, "2", "1000000")
+
+	id := string(s.mustInvoke("relief", "transaction", "relief", "tentco", "40", "tents"))
+	s.mustFail("tentco", "approveSpend", "tentco", id)
+	s.mustInvoke("chair", "approveSpend", "chair", id)
+	s.mustFail("chair", "approveSpend", "chair", id)
+	s.mustFail("chair", "approveSpend", "treasurer", id)
+	if s.balance("tentco") != 0 {
+		t.Errorf("spend paid out on one genuine signature")
+	}
+}
@@ -0,0 +1,386 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========payee whitelist records===============
+var payeeListPrefix = "payees:"
+var payeeChangePrefix = "payeechg:"
+
+const (
+	payeeAdd        = "ADD"
+	payeeRemove     = "REMOVE"
+	payeeUnrestrict = "UNRESTRICT"
+
+	payeeByAccount = "ACCOUNT"
+	payeeByType    = "TYPE"
+)
+
+// PayeeEntry whitelists one account, or every account of a type
+type PayeeEntry struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// PayeeList is the whitelist of an account. Once restricted the account can only pay the
+// payees on it, whichever way the payment is made, even after the last one is removed. Only
+// an approved UNRESTRICT change lifts the restriction.
+type PayeeList struct {
+	Account    string       `json:"account"`
+	Restricted bool         `json:"restricted"`
+	Entries    []PayeeEntry `json:"entries"`
+}
+
+// PayeeChange is an owner's request to add or remove a payee, or to lift the whitelist. It
+// applies once an admin approves it.
+type PayeeChange struct {
+	ID          string     `json:"id"`
+	Account     string     `json:"account"`
+	Action      string     `json:"action"`
+	Entry       PayeeEntry `json:"entry"`
+	Status      string     `json:"status"`
+	RequestedBy string     `json:"requestedBy"`
+	RequestedAt int64      `json:"requestedAt"`
+	DecidedBy   string     `json:"decidedBy,omitempty"`
+	DecidedAt   int64      `json:"decidedAt,omitempty"`
+}
+
+// PayeeWhitelist is what getPayeeWhitelist returns
+type PayeeWhitelist struct {
+	PayeeList
+	Pending []PayeeChange `json:"pending"`
+}
+
+//============end==========payee whitelist records===============
+
+func getPayeeList(stub shim.ChaincodeStubInterface, accountID string) (PayeeList, error) {
+	list := PayeeList{Account: accountID, Entries: []PayeeEntry{}}
+	listBytes, err := stub.GetState(payeeListPrefix + accountID)
+	if err != nil {
+		return list, errors.New("Error reading payee whitelist of " + accountID)
+	}
+	if len(listBytes) == 0 {
+		return list, nil
+	}
+	err = json.Unmarshal(listBytes, &list)
+	if err != nil {
+		return list, errors.New("Error unmarshalling payee whitelist of " + accountID)
+	}
+	// Whitelists stored before the flag existed were restricted while they had entries
+	if len(list.Entries) > 0 {
+		list.Restricted = true
+	}
+	return list, nil
+}
+
+func putPayeeList(stub shim.ChaincodeStubInterface, list PayeeList) error {
+	if !list.Restricted {
+		err := stub.DelState(payeeListPrefix + list.Account)
+		if err != nil {
+			return errors.New("Error deleting payee whitelist of " + list.Account)
+		}
+		return nil
+	}
+	listBytes, err := json.Marshal(&list)
+	if err != nil {
+		return errors.New("Error marshalling payee whitelist of " + list.Account)
+	}
+	err = stub.PutState(payeeListPrefix+list.Account, listBytes)
+	if err != nil {
+		return errors.New("Error writing payee whitelist of " + list.Account)
+	}
+	return nil
+}
+
+func getPayeeChange(stub shim.ChaincodeStubInterface, changeID string) (PayeeChange, error) {
+	var change PayeeChange
+	changeBytes, err := stub.GetState(payeeChangePrefix + changeID)
+	if err != nil || len(changeBytes) == 0 {
+		fmt.Println("Payee change not found " + changeID)
+		return change, errors.New("Payee change not found " + changeID)
+	}
+
+	err = json.Unmarshal(changeBytes, &change)
+	if err != nil {
+		fmt.Println("Error unmarshalling payee change " + changeID + "\n err:" + err.Error())
+		return change, errors.New("Error unmarshalling payee change " + changeID)
+	}
+	return change, nil
+}
+
+func putPayeeChange(stub shim.ChaincodeStubInterface, change PayeeChange) error {
+	changeBytes, err := json.Marshal(&change)
+	if err != nil {
+		fmt.Println("Error marshalling payee change " + change.ID)
+		return errors.New("Error marshalling payee change " + change.ID)
+	}
+	err = stub.PutState(payeeChangePrefix+change.ID, changeBytes)
+	if err != nil {
+		fmt.Println("Error writing payee change " + change.ID)
+		return errors.New("Error writing payee change " + change.ID)
+	}
+	return nil
+}
+
+// findPayee returns the position of an entry in a whitelist, or -1
+func findPayee(list PayeeList, entry PayeeEntry) int {
+	for i, existing := range list.Entries {
+		if existing == entry {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkPayee refuses a payment to a payee that isn't on the payer's whitelist. Every
+// transfer checks it; functions that hold funds for a later payment check it up front too.
+func checkPayee(stub shim.ChaincodeStubInterface, fromID string, toID string) error {
+	list, err := getPayeeList(stub, fromID)
+	if err != nil || !list.Restricted {
+		return err
+	}
+	payee, err := GetCompany(toID, stub)
+	if err != nil {
+		return err
+	}
+	if findPayee(list, PayeeEntry{Type: payeeByAccount, Value: toID}) >= 0 || findPayee(list, PayeeEntry{Type: payeeByType, Value: accountType(payee)}) >= 0 {
+		return nil
+	}
+	fmt.Println("===================" + toID + " is not on the payee whitelist of " + fromID)
+	return errors.New(toID + " is not on the payee whitelist of " + fromID)
+}
+
+//===========================start============payee whitelist functions=================================================
+// requestPayeeChange asks to add a payee to, or remove one from, the owner's whitelist, or
+// to lift the whitelist altogether. The change waits for an admin to approve it; its id is
+// returned. The owner has to be the caller.
+// args: owner, ADD|REMOVE, ACCOUNT|TYPE, account id or account type
+// or: owner, UNRESTRICT
+func (t *SimpleChaincode) requestPayeeChange(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Requesting payee change.=========================")
+
+	if len(args) != 4 && len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting owner, ADD or REMOVE, ACCOUNT or TYPE and payee, or owner and UNRESTRICT")
+	}
+
+	err := checkCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = GetCompany(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+	list, err := getPayeeList(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var entry PayeeEntry
+	if args[1] == payeeUnrestrict {
+		if len(args) != 2 {
+			return nil, errors.New("Incorrect number of arguments. Expecting owner and UNRESTRICT")
+		}
+		if !list.Restricted {
+			return nil, errors.New(args[0] + " has no payee whitelist")
+		}
+	} else if args[1] != payeeAdd && args[1] != payeeRemove {
+		return nil, errors.New("Expecting ADD, REMOVE or UNRESTRICT, not " + args[1])
+	} else if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting owner, ADD or REMOVE, ACCOUNT or TYPE and payee")
+	} else {
+		entry = PayeeEntry{Type: args[2], Value: args[3]}
+		if entry.Type == payeeByAccount {
+			if entry.Value == args[0] {
+				return nil, errors.New("Cannot whitelist " + args[0] + " as its own payee")
+			}
+			_, err = GetCompany(entry.Value, stub)
+			if err != nil {
+				return nil, err
+			}
+		} else if entry.Type == payeeByType {
+			if entry.Value == "ANY" || !validFeeType(entry.Value) {
+				return nil, errors.New("Invalid account type " + entry.Value)
+			}
+		} else {
+			return nil, errors.New("Expecting ACCOUNT or TYPE, not " + entry.Type)
+		}
+		if args[1] == payeeAdd && findPayee(list, entry) >= 0 {
+			return nil, errors.New(entry.Value + " is already on the payee whitelist of " + args[0])
+		}
+		if args[1] == payeeRemove && findPayee(list, entry) < 0 {
+			return nil, errors.New(entry.Value + " is not on the payee whitelist of " + args[0])
+		}
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	changeID, err := nextID(stub, "PYE")
+	if err != nil {
+		return nil, err
+	}
+	err = putPayeeChange(stub, PayeeChange{ID: changeID, Account: args[0], Action: args[1], Entry: entry, Status: proposalPending, RequestedBy: args[0], RequestedAt: now})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Payee change " + changeID + " awaiting approval ====***====================")
+	return []byte(changeID), nil
+}
+
+// approvePayeeChange applies a pending payee change to the whitelist and returns the change,
+// with who requested it, for the admin's records
+// args: admin, change id
+func (t *SimpleChaincode) approvePayeeChange(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Approving payee change.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin and change id")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to approve payee change for " + args[0])
+	}
+	change, err := getPayeeChange(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if change.Status != proposalPending {
+		return nil, errors.New("Payee change " + change.ID + " is " + change.Status)
+	}
+	if change.Account == args[0] || change.RequestedBy == args[0] {
+		return nil, errors.New("Payee change " + change.ID + " needs approving by another admin")
+	}
+
+	list, err := getPayeeList(stub, change.Account)
+	if err != nil {
+		return nil, err
+	}
+	// Removing the last payee leaves the account unable to pay anyone, not unrestricted
+	i := findPayee(list, change.Entry)
+	if change.Action == payeeAdd && i < 0 {
+		list.Entries = append(list.Entries, change.Entry)
+		list.Restricted = true
+	} else if change.Action == payeeRemove && i >= 0 {
+		list.Entries = append(list.Entries[:i], list.Entries[i+1:]...)
+	} else if change.Action == payeeUnrestrict {
+		list.Entries = []PayeeEntry{}
+		list.Restricted = false
+	}
+	err = putPayeeList(stub, list)
+	if err != nil {
+		return nil, err
+	}
+
+	change.DecidedAt, err = txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	change.DecidedBy = args[0]
+	change.Status = proposalApplied
+	err = putPayeeChange(stub, change)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Payee change " + change.ID + " requested by " + change.RequestedBy + " applied ====***====================")
+	return json.Marshal(change)
+}
+
+// cancelPayeeChange drops a pending payee change. The owner can withdraw it and an
+// admin can turn it down.
+// args: owner or admin, change id
+func (t *SimpleChaincode) cancelPayeeChange(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Cancelling payee change.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting owner or admin and change id")
+	}
+
+	change, err := getPayeeChange(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if change.Account == args[0] {
+		err = checkCaller(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = getAccountOfType(stub, args[0], "ADMIN")
+		if err != nil {
+			return nil, errors.New("Invalid Reuest to cancel payee change for " + args[0])
+		}
+	}
+	if change.Status != proposalPending {
+		return nil, errors.New("Payee change " + change.ID + " is " + change.Status)
+	}
+
+	change.DecidedAt, err = txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	change.DecidedBy = args[0]
+	change.Status = proposalCancelled
+	return nil, putPayeeChange(stub, change)
+}
+
+//===========================end============payee whitelist functions=================================================
+
+//===========================start============payee whitelist queries=================================================
+// getPayeeWhitelist returns an account's payee whitelist along with the changes waiting for approval
+// args: account
+func getPayeeWhitelist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	list, err := getPayeeList(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	whitelist := PayeeWhitelist{PayeeList: list, Pending: []PayeeChange{}}
+	err = scanPrefix(stub, payeeChangePrefix, func(key string, value []byte) error {
+		var change PayeeChange
+		err := json.Unmarshal(value, &change)
+		if err != nil {
+			return errors.New("Error unmarshalling payee change " + key)
+		}
+		if change.Account == args[0] && change.Status == proposalPending {
+			whitelist.Pending = append(whitelist.Pending, change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(whitelist)
+}
+
+//===========================end============payee whitelist queries=================================================
@@ -0,0 +1,50 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestApprovedPayeeCanBePaid(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	id := string(s.mustInvoke("relief", "requestPayeeChange", "relief", "ADD", "ACCOUNT", "tentco"))
+	s.mustInvoke("root", "approvePayeeChange", "root", id)
+
+	s.mustInvoke("relief", "transaction", "relief", "tentco", "10", "tents")
+	if s.balance("tentco") != 10 {
+		t.Errorf("whitelisted tentco received %v, want 10", s.balance("tentco"))
+	}
+}
+
+func TestPayeeWhitelistRefusesOthers(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	s.openAccount("canvas", "VENDOR", "0")
+	s.mustFail("tentco", "requestPayeeChange", "relief", "ADD", "ACCOUNT", "tentco")
+	id := string(s.mustInvoke("relief", "requestPayeeChange", "relief", "ADD", "ACCOUNT", "tentco"))
+	s.mustFail("canvas", "approvePayeeChange", "canvas", id)
+	s.mustFail("tentco", "cancelPayeeChange", "relief", id)
+	s.mustInvoke("root", "approvePayeeChange", "root", id)
+
+	s.mustFail("relief", "transaction", "relief", "canvas", "10", "tarps")
+	if s.balance("canvas") != 0 {
+		t.Errorf("canvas was paid %v off the whitelist", s.balance("canvas"))
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========pending transfer records===============
+var pendingTransferPrefix = "ptxfr:"
+var transferApproversPrefix = "approvers:"
+
+const (
+	pendingTransferPending  = "PENDING"
+	pendingTransferApproved = "APPROVED"
+	pendingTransferRejected = "REJECTED"
+)
+
+// PendingTransfer is a payment waiting for review. Its amount is held on the payer's
+// account until an approver settles or rejects it.
+type PendingTransfer struct {
+	ID          string  `json:"id"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	Amount      float64 `json:"amount"`
+	Memo        string  `json:"memo,omitempty"`
+	Status      string  `json:"status"`
+	DecidedBy   string  `json:"decidedBy,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+	RequestedAt int64   `json:"requestedAt"`
+	DecidedAt   int64   `json:"decidedAt,omitempty"`
+}
+
+//============end==========pending transfer records===============
+
+func getPendingTransfer(stub shim.ChaincodeStubInterface, transferID string) (PendingTransfer, error) {
+	var transfer PendingTransfer
+	transferBytes, err := stub.GetState(pendingTransferPrefix + transferID)
+	if err != nil || len(transferBytes) == 0 {
+		fmt.Println("Pending transfer not found " + transferID)
+		return transfer, errors.New("Pending transfer not found " + transferID)
+	}
+
+	err = json.Unmarshal(transferBytes, &transfer)
+	if err != nil {
+		fmt.Println("Error unmarshalling pending transfer " + transferID + "\n err:" + err.Error())
+		return transfer, errors.New("Error unmarshalling pending transfer " + transferID)
+	}
+	return transfer, nil
+}
+
+func putPendingTransfer(stub shim.ChaincodeStubInterface, transfer PendingTransfer) error {
+	transferBytes, err := json.Marshal(&transfer)
+	if err != nil {
+		fmt.Println("Error marshalling pending transfer " + transfer.ID)
+		return errors.New("Error marshalling pending transfer " + transfer.ID)
+	}
+	err = stub.PutState(pendingTransferPrefix+transfer.ID, transferBytes)
+	if err != nil {
+		fmt.Println("Error writing pending transfer " + transfer.ID)
+		return errors.New("Error writing pending transfer " + transfer.ID)
+	}
+	return nil
+}
+
+// canApproveTransfer checks that approver is an admin or one of the payer's designated
+// approvers. Neither party to a payment approves it.
+func canApproveTransfer(stub shim.ChaincodeStubInterface, approver string, transfer PendingTransfer) (bool, error) {
+	if approver == transfer.From || approver == transfer.To {
+		return false, nil
+	}
+	admin, err := isAdmin(stub, approver)
+	if err != nil || admin {
+		return admin, err
+	}
+
+	var approvers []string
+	approversBytes, err := stub.GetState(transferApproversPrefix + transfer.From)
+	if err != nil {
+		return false, errors.New("Error reading approvers of " + transfer.From)
+	}
+	if len(approversBytes) > 0 {
+		err = json.Unmarshal(approversBytes, &approvers)
+		if err != nil {
+			return false, errors.New("Error unmarshalling approvers of " + transfer.From)
+		}
+	}
+	return listContains(approvers, approver), nil
+}
+
+// decidePendingTransfer loads a pending transfer and checks approver, the caller, may decide it
+func decidePendingTransfer(stub shim.ChaincodeStubInterface, approver string, transferID string) (PendingTransfer, error) {
+	err := checkCaller(stub, approver)
+	if err != nil {
+		return PendingTransfer{}, err
+	}
+	transfer, err := getPendingTransfer(stub, transferID)
+	if err != nil {
+		return transfer, err
+	}
+	if transfer.Status != pendingTransferPending {
+		return transfer, errors.New("Transfer " + transfer.ID + " is " + transfer.Status)
+	}
+	allowed, err := canApproveTransfer(stub, approver, transfer)
+	if err != nil {
+		return transfer, err
+	}
+	if !allowed {
+		fmt.Println("===================" + approver + " may not approve transfers from " + transfer.From)
+		return transfer, errors.New(approver + " is not an approver for " + transfer.From)
+	}
+
+	transfer.DecidedAt, err = txTimestampMs(stub)
+	if err != nil {
+		return transfer, err
+	}
+	transfer.DecidedBy = approver
+	return transfer, releaseHold(stub, transfer.From, transfer.Amount)
+}
+
+//===========================start============pending transfer functions=================================================
+// setTransferApprovers names the accounts allowed to approve an account's pending transfers.
+// Only an admin names them, so an account can't pick its own reviewers.
+// args: admin, account, approvers (JSON array)
+func (t *SimpleChaincode) setTransferApprovers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting transfer approvers.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, account and approvers")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set transfer approvers for " + args[0])
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+	var approvers []string
+	err = json.Unmarshal([]byte(args[2]), &approvers)
+	if err != nil {
+		return nil, errors.New("Invalid approvers " + args[2])
+	}
+	for _, approver := range approvers {
+		if approver == args[1] {
+			return nil, errors.New("An account can't approve its own transfers")
+		}
+		_, err = GetCompany(approver, stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	approversBytes, err := json.Marshal(approvers)
+	if err != nil {
+		return nil, errors.New("Error marshalling approvers of " + args[1])
+	}
+	err = stub.PutState(transferApproversPrefix+args[1], approversBytes)
+	if err != nil {
+		return nil, errors.New("Error writing approvers of " + args[1])
+	}
+	return nil, nil
+}
+
+// requestTransfer holds the amount on the payer's account and records a transfer for review
+// args: from, to, amount[, memo]
+func (t *SimpleChaincode) requestTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Requesting transfer.=========================")
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from, to, amount and optional memo")
+	}
+
+	if args[0] == args[1] {
+		return nil, errors.New("Cannot transfer from " + args[0] + " to itself")
+	}
+	_, err := GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+
+	// Multisig accounts can't hold funds for a payment their signers haven't approved
+	err = checkSingleSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse up front what approval would refuse, rather than holding funds for it
+	err = checkParties(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkPayee(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkSpendingLimits(stub, args[0], amount, baseCurrency)
+	if err != nil {
+		return nil, err
+	}
+	err = placeHold(stub, args[0], amount)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	transferID, err := nextID(stub, "PTX")
+	if err != nil {
+		return nil, err
+	}
+	transfer := PendingTransfer{ID: transferID, From: args[0], To: args[1], Amount: amount, Status: pendingTransferPending, RequestedAt: now}
+	if len(args) == 4 {
+		transfer.Memo = args[3]
+	}
+	err = putPendingTransfer(stub, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Transfer " + transferID + " awaiting approval ====***====================")
+	return []byte(transferID), nil
+}
+
+// approveTransfer releases the hold and settles a pending transfer
+// args: approver, transfer id
+func (t *SimpleChaincode) approveTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Approving transfer.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting approver and transfer id")
+	}
+
+	transfer, err := decidePendingTransfer(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	_, err = transferFunds(stub, transfer.From, transfer.To, transfer.Amount, transfer.Memo)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer.Status = pendingTransferApproved
+	return nil, putPendingTransfer(stub, transfer)
+}
+
+// rejectTransfer releases the hold without moving any money
+// args: approver, transfer id, reason
+func (t *SimpleChaincode) rejectTransfer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Rejecting transfer.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting approver, transfer id and reason")
+	}
+
+	transfer, err := decidePendingTransfer(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	transfer.Status = pendingTransferRejected
+	transfer.Reason = args[2]
+	return nil, putPendingTransfer(stub, transfer)
+}
+
+//===========================end============pending transfer functions=================================================
+
+//===========================start============pending transfer queries=================================================
+// getPendingTransfers lists the transfers awaiting review that an account pays or receives
+// args: account
+func getPendingTransfers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	transfers := []PendingTransfer{}
+	err := scanPrefix(stub, pendingTransferPrefix, func(key string, value []byte) error {
+		var transfer PendingTransfer
+		err := json.Unmarshal(value, &transfer)
+		if err != nil {
+			return errors.New("Error unmarshalling pending transfer " + key)
+		}
+		if transfer.Status == pendingTransferPending && (transfer.From == args[0] || transfer.To == args[0]) {
+			transfers = append(transfers, transfer)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(transfers)
+}
+
+//===========================end============pending transfer queries=================================================
@@ -0,0 +1,56 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestApproveRequestedTransfer(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "100")
+	s.openAccount("tentco", "VENDOR", "0")
+	s.openAccount("auditor", "CORPORATE", "0")
+	s.mustInvoke("root", "setTransferApprovers", "root", "relief", `["auditor"]`)
+
+	id := string(s.mustInvoke("relief", "requestTransfer", "relief", "tentco", "80", "tents"))
+	if s.balance("tentco") != 0 {
+		t.Fatalf("requested transfer paid out before approval")
+	}
+	s.mustInvoke("auditor", "approveTransfer", "auditor", id)
+	if s.balance("tentco") != 80 || s.balance("relief") != 20 {
+		t.Errorf("approved transfer paid tentco %v, want 80", s.balance("tentco"))
+	}
+}
+
+func TestApproveTransferRefusesPartiesAndOutsiders(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "100")
+	s.openAccount("tentco", "VENDOR", "0")
+	s.openAccount("auditor", "CORPORATE", "0")
+	s.mustFail("relief", "setTransferApprovers", "relief", "relief", `["auditor"]`)
+	s.mustInvoke("root", "setTransferApprovers", "root", "relief", `["auditor","tentco"]`)
+
+	id := string(s.mustInvoke("relief", "requestTransfer", "relief", "tentco", "80", "tents"))
+	s.mustFail("relief", "transaction", "relief", "tentco", "30", "around the approvers")
+	s.mustFail("relief", "approveTransfer", "relief", id)
+	s.mustFail("tentco", "approveTransfer", "tentco", id)
+	s.mustFail("relief", "approveTransfer", "auditor", id)
+	s.mustInvoke("root", "rejectTransfer", "root", id, "duplicate order")
+	if s.balance("tentco") != 0 || s.balance("relief") != 100 {
+		t.Errorf("rejected transfer moved %v to tentco", s.balance("tentco"))
+	}
+}
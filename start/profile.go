@@ -0,0 +1,292 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========profile records===============
+var profilePrefix = "profile:"
+var profileChangePrefix = "profilechg:"
+
+// Profile holds the details of a CORPORATE, NGO or VENDOR account. The legal name and
+// registration number go on tax receipts and the category files the account for things like
+// voucher restrictions, so only admins can change those three.
+type Profile struct {
+	Account            string `json:"account"`
+	LegalName          string `json:"legalName,omitempty"`
+	RegistrationNumber string `json:"registrationNumber,omitempty"`
+	Country            string `json:"country,omitempty"`
+	ContactEmail       string `json:"contactEmail,omitempty"`
+	ContactPhone       string `json:"contactPhone,omitempty"`
+	Category           string `json:"category,omitempty"`
+	UpdatedBy          string `json:"updatedBy,omitempty"`
+	UpdatedAt          int64  `json:"updatedAt,omitempty"`
+}
+
+// ProfileChange records one field of a profile changing
+type ProfileChange struct {
+	ID        string `json:"id"`
+	Account   string `json:"account"`
+	Field     string `json:"field"`
+	OldValue  string `json:"oldValue"`
+	NewValue  string `json:"newValue"`
+	ChangedBy string `json:"changedBy"`
+	Time      int64  `json:"time"`
+}
+
+//============end==========profile records===============
+
+// getProfile returns an account's profile, or nil when none has been filled in
+func getProfile(stub shim.ChaincodeStubInterface, accountID string) (*Profile, error) {
+	profileBytes, err := stub.GetState(profilePrefix + accountID)
+	if err != nil {
+		return nil, errors.New("Error reading profile of " + accountID)
+	}
+	if len(profileBytes) == 0 {
+		return nil, nil
+	}
+	var profile Profile
+	err = json.Unmarshal(profileBytes, &profile)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling profile of " + accountID)
+	}
+	return &profile, nil
+}
+
+// profileField maps an updateProfile field name to the profile field it sets
+func profileField(profile *Profile, field string) *string {
+	switch field {
+	case "legalName":
+		return &profile.LegalName
+	case "registrationNumber":
+		return &profile.RegistrationNumber
+	case "country":
+		return &profile.Country
+	case "contactEmail":
+		return &profile.ContactEmail
+	case "contactPhone":
+		return &profile.ContactPhone
+	case "category":
+		return &profile.Category
+	}
+	return nil
+}
+
+// adminProfileField reports whether only an admin can set a profile field
+func adminProfileField(field string) bool {
+	return field == "legalName" || field == "registrationNumber" || field == "category"
+}
+
+func onlyChars(value string, allowed string) bool {
+	for _, c := range value {
+		if !strings.ContainsRune(allowed, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateProfileField checks a new value for a profile field. Empty values clear optional
+// fields; the legal name can't be cleared once set.
+func validateProfileField(field string, value string) error {
+	const upper = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	const digits = "0123456789"
+
+	if profileField(&Profile{}, field) == nil {
+		return errors.New("Unknown profile field " + field)
+	}
+	if value == "" {
+		if field == "legalName" {
+			return errors.New("Legal name can't be empty")
+		}
+		return nil
+	}
+	switch field {
+	case "legalName":
+		if len(value) > 200 {
+			return errors.New("Legal name is longer than 200 characters")
+		}
+	case "registrationNumber":
+		if len(value) > 50 || !onlyChars(value, upper+strings.ToLower(upper)+digits+"-/. ") {
+			return errors.New("Invalid registration number " + value)
+		}
+	case "country":
+		if len(value) != 2 || !onlyChars(value, upper) {
+			return errors.New("Country must be a two letter ISO code, not " + value)
+		}
+	case "contactEmail":
+		at := strings.Index(value, "@")
+		if len(value) > 254 || at < 1 || strings.Count(value, "@") != 1 || !strings.Contains(value[at+1:], ".") || strings.ContainsAny(value, " \t") {
+			return errors.New("Invalid contact email " + value)
+		}
+	case "contactPhone":
+		number := strings.TrimPrefix(value, "+")
+		if len(number) < 6 || len(number) > 20 || !onlyChars(number, digits+" -") {
+			return errors.New("Invalid contact phone " + value)
+		}
+	case "category":
+		if len(value) > 50 || !onlyChars(value, upper+strings.ToLower(upper)+digits+"-_ ") {
+			return errors.New("Category must be letters, digits, spaces, - or _, not " + value)
+		}
+	}
+	return nil
+}
+
+// applyProfileUpdate validates and sets profile fields, logging a change for every field whose
+// value changes. The caller must be the account itself or an admin, and only admins set the
+// legal name, registration number and category.
+func applyProfileUpdate(stub shim.ChaincodeStubInterface, caller string, accountID string, fields map[string]string) error {
+	account, err := GetCompany(accountID, stub)
+	if err != nil {
+		return err
+	}
+	if accountType(account) == "ADMIN" || accountType(account) == "" {
+		return errors.New("Account " + accountID + " doesn't have a profile")
+	}
+	isAdmin := false
+	if caller != accountID {
+		_, err = getAccountOfType(stub, caller, "ADMIN")
+		if err != nil {
+			return errors.New("Invalid Reuest to update profile for " + caller)
+		}
+		isAdmin = true
+	}
+
+	profile, err := getProfile(stub, accountID)
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		profile = &Profile{Account: accountID}
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return err
+	}
+
+	// Sorted so the change ids come out the same on every peer
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := strings.TrimSpace(fields[name])
+		err = validateProfileField(name, value)
+		if err != nil {
+			return err
+		}
+		if adminProfileField(name) && !isAdmin {
+			return errors.New("Only an admin can set the " + name + " of " + accountID)
+		}
+		current := profileField(profile, name)
+		if *current == value {
+			continue
+		}
+
+		changeID, err := nextID(stub, "PRF")
+		if err != nil {
+			return err
+		}
+		change := ProfileChange{ID: changeID, Account: accountID, Field: name, OldValue: *current, NewValue: value, ChangedBy: caller, Time: now}
+		changeBytes, err := json.Marshal(&change)
+		if err != nil {
+			return errors.New("Error marshalling profile change " + changeID)
+		}
+		err = stub.PutState(profileChangePrefix+accountID+":"+changeID, changeBytes)
+		if err != nil {
+			return errors.New("Error writing profile change " + changeID)
+		}
+		*current = value
+	}
+	profile.UpdatedBy = caller
+	profile.UpdatedAt = now
+	profileBytes, err := json.Marshal(profile)
+	if err != nil {
+		return errors.New("Error marshalling profile of " + accountID)
+	}
+	err = stub.PutState(profilePrefix+accountID, profileBytes)
+	if err != nil {
+		return errors.New("Error writing profile of " + accountID)
+	}
+	return nil
+}
+
+//===========================start============profile functions=================================================
+// updateProfile sets profile fields of a CORPORATE, NGO or VENDOR account. Fields are
+// legalName, registrationNumber, country, contactEmail, contactPhone and category; the
+// account itself can only set the contact details and country.
+// args: caller (the account or an admin), account, fields (JSON object of field to value)
+func (t *SimpleChaincode) updateProfile(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Updating profile.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, account and fields")
+	}
+
+	var fields map[string]string
+	err := json.Unmarshal([]byte(args[2]), &fields)
+	if err != nil || len(fields) == 0 {
+		return nil, errors.New("Invalid profile fields " + args[2])
+	}
+	err = applyProfileUpdate(stub, args[0], args[1], fields)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Profile of " + args[1] + " updated by " + args[0] + " ====***====================")
+	return nil, nil
+}
+
+//===========================end============profile functions=================================================
+
+//===========================start============profile queries=================================================
+// getProfileHistory lists every change made to an account's profile, oldest first
+// args: account
+func getProfileHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	changes := []ProfileChange{}
+	err := scanPrefix(stub, profileChangePrefix+args[0]+":", func(key string, value []byte) error {
+		var change ProfileChange
+		err := json.Unmarshal(value, &change)
+		if err != nil {
+			return errors.New("Error unmarshalling profile change " + key)
+		}
+		changes = append(changes, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(changes)
+}
+
+//===========================end============profile queries=================================================
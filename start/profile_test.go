@@ -0,0 +1,66 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestValidateProfileFieldRejectsUnknownFields(t *testing.T) {
+	for _, value := range []string{"", "anything"} {
+		if validateProfileField("bogus", value) == nil {
+			t.Errorf("unknown field bogus with value %q was accepted", value)
+		}
+	}
+}
+
+func TestValidateProfileFieldClearsOptionalFields(t *testing.T) {
+	if err := validateProfileField("contactPhone", ""); err != nil {
+		t.Errorf("clearing contactPhone: %v", err)
+	}
+	if validateProfileField("legalName", "") == nil {
+		t.Errorf("clearing legalName was accepted")
+	}
+}
+
+func TestUpdateProfileByAdminAndOwner(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "0")
+	s.mustInvoke("root", "updateProfile", "root", "acme", `{"legalName":"Acme Ltd","registrationNumber":"GB-1"}`)
+	s.mustInvoke("acme", "updateProfile", "acme", "acme", `{"contactEmail":"giving@acme.com","country":"GB"}`)
+
+	profile, err := getProfile(s, "acme")
+	if err != nil {
+		t.Fatalf("getProfile: %v", err)
+	}
+	if profile == nil || profile.LegalName != "Acme Ltd" || profile.ContactEmail != "giving@acme.com" || profile.UpdatedBy != "acme" {
+		t.Errorf("profile after admin and owner updates is %+v", profile)
+	}
+}
+
+func TestUpdateProfileRefusesOwnerRegistrationAndOthers(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "0")
+	s.openAccount("rival", "CORPORATE", "0")
+	s.mustFail("acme", "updateProfile", "acme", "acme", `{"registrationNumber":"GB-2"}`)
+	s.mustFail("rival", "updateProfile", "rival", "acme", `{"country":"FR"}`)
+	s.mustFail("acme", "updateProfile", "acme", "acme", `{"contactEmail":"not an address"}`)
+	s.mustFail("root", "updateProfile", "root", "root", `{"legalName":"Root"}`)
+
+	if profile, _ := getProfile(s, "acme"); profile != nil {
+		t.Errorf("refused updates left a profile %+v", profile)
+	}
+}
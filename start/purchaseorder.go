@@ -0,0 +1,433 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========purchase order records===============
+var purchaseOrderPrefix = "po:"
+var goodsReceiptPrefix = "grn:"
+var matchToleranceKey = "cfg:matchTolerance"
+
+const (
+	purchaseOrderOpen   = "OPEN"
+	purchaseOrderClosed = "CLOSED"
+)
+
+type PurchaseOrderLine struct {
+	Description      string  `json:"description"`
+	Quantity         float64 `json:"quantity"`
+	UnitPrice        float64 `json:"unitPrice"`
+	ReceivedQuantity float64 `json:"receivedQuantity"`
+	InvoicedQuantity float64 `json:"invoicedQuantity"`
+}
+
+type PurchaseOrder struct {
+	ID        string              `json:"id"`
+	NGO       string              `json:"ngo"`
+	Vendor    string              `json:"vendor"`
+	Lines     []PurchaseOrderLine `json:"lines"`
+	Amount    float64             `json:"amount"`
+	Status    string              `json:"status"`
+	CreatedAt int64               `json:"createdAt"`
+}
+
+type ReceiptLine struct {
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+}
+
+type GoodsReceipt struct {
+	ID            string        `json:"id"`
+	PurchaseOrder string        `json:"purchaseOrder"`
+	NGO           string        `json:"ngo"`
+	Lines         []ReceiptLine `json:"lines"`
+	ReceivedAt    int64         `json:"receivedAt"`
+}
+
+// MatchTolerance holds how far an invoice may drift from its purchase order and receipts.
+// Quantity and price tolerances are percentages, the amount tolerance is absolute.
+type MatchTolerance struct {
+	QuantityPct float64 `json:"quantityPct"`
+	PricePct    float64 `json:"pricePct"`
+	Amount      float64 `json:"amount"`
+}
+
+type MatchResult struct {
+	Invoice       string   `json:"invoice"`
+	PurchaseOrder string   `json:"purchaseOrder"`
+	Mismatches    []string `json:"mismatches"`
+}
+
+//============end==========purchase order records===============
+
+func getPurchaseOrder(stub shim.ChaincodeStubInterface, poID string) (PurchaseOrder, error) {
+	var po PurchaseOrder
+	poBytes, err := stub.GetState(purchaseOrderPrefix + poID)
+	if err != nil || len(poBytes) == 0 {
+		fmt.Println("Purchase order not found " + poID)
+		return po, errors.New("Purchase order not found " + poID)
+	}
+
+	err = json.Unmarshal(poBytes, &po)
+	if err != nil {
+		fmt.Println("Error unmarshalling purchase order " + poID + "\n err:" + err.Error())
+		return po, errors.New("Error unmarshalling purchase order " + poID)
+	}
+	return po, nil
+}
+
+func putPurchaseOrder(stub shim.ChaincodeStubInterface, po PurchaseOrder) error {
+	poBytes, err := json.Marshal(&po)
+	if err != nil {
+		fmt.Println("Error marshalling purchase order " + po.ID)
+		return errors.New("Error marshalling purchase order " + po.ID)
+	}
+	err = stub.PutState(purchaseOrderPrefix+po.ID, poBytes)
+	if err != nil {
+		fmt.Println("Error writing purchase order " + po.ID)
+		return errors.New("Error writing purchase order " + po.ID)
+	}
+	return nil
+}
+
+func getMatchTolerance(stub shim.ChaincodeStubInterface) (MatchTolerance, error) {
+	var tolerance MatchTolerance
+	toleranceBytes, err := stub.GetState(matchToleranceKey)
+	if err != nil {
+		return tolerance, errors.New("Error reading match tolerance")
+	}
+	// No tolerance configured means invoices have to match exactly
+	if len(toleranceBytes) == 0 {
+		return tolerance, nil
+	}
+	err = json.Unmarshal(toleranceBytes, &tolerance)
+	if err != nil {
+		return tolerance, errors.New("Error unmarshalling match tolerance")
+	}
+	return tolerance, nil
+}
+
+//===========================start============purchase orders=================================================
+// createPurchaseOrder records an order placed by an NGO with a vendor
+// args: ngo, vendor, lines (JSON array of description, quantity, unitPrice)
+func (t *SimpleChaincode) createPurchaseOrder(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Creating purchase order.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo, vendor and order lines")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "NGO")
+	if err != nil {
+		return nil, err
+	}
+	_, err = getAccountOfType(stub, args[1], "VENDOR")
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []PurchaseOrderLine
+	err = json.Unmarshal([]byte(args[2]), &lines)
+	if err != nil || len(lines) == 0 {
+		fmt.Println("===============Invalid order lines " + args[2])
+		return nil, errors.New("Invalid order lines for purchase order")
+	}
+	var amount float64
+	seen := map[string]bool{}
+	for i := range lines {
+		if lines[i].Description == "" || seen[lines[i].Description] {
+			return nil, errors.New("Order lines need a unique description")
+		}
+		if lines[i].Quantity <= 0 || lines[i].UnitPrice < 0 {
+			return nil, errors.New("Invalid quantity or price on order line " + lines[i].Description)
+		}
+		seen[lines[i].Description] = true
+		lines[i].ReceivedQuantity = 0
+		lines[i].InvoicedQuantity = 0
+		amount += lines[i].Quantity * lines[i].UnitPrice
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	poID, err := nextID(stub, "PO")
+	if err != nil {
+		return nil, err
+	}
+
+	po := PurchaseOrder{ID: poID, NGO: args[0], Vendor: args[1], Lines: lines, Amount: amount, Status: purchaseOrderOpen, CreatedAt: now}
+	err = putPurchaseOrder(stub, po)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Purchase order " + poID + " created ====***====================")
+	return []byte(poID), nil
+}
+
+// recordGoodsReceipt confirms delivery of some or all of the goods on a purchase order
+// args: ngo, purchase order id, lines (JSON array of description, quantity)
+func (t *SimpleChaincode) recordGoodsReceipt(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Recording goods receipt.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo, purchase order id and received lines")
+	}
+
+	po, err := getPurchaseOrder(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if po.NGO != args[0] {
+		return nil, errors.New("Purchase order " + po.ID + " was not placed by " + args[0])
+	}
+	if po.Status != purchaseOrderOpen {
+		return nil, errors.New("Purchase order " + po.ID + " is " + po.Status)
+	}
+
+	var lines []ReceiptLine
+	err = json.Unmarshal([]byte(args[2]), &lines)
+	if err != nil || len(lines) == 0 {
+		fmt.Println("===============Invalid received lines " + args[2])
+		return nil, errors.New("Invalid received lines for goods receipt")
+	}
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			return nil, errors.New("Invalid received quantity for " + line.Description)
+		}
+		found := false
+		for i := range po.Lines {
+			if po.Lines[i].Description == line.Description {
+				po.Lines[i].ReceivedQuantity += line.Quantity
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("Purchase order " + po.ID + " has no line " + line.Description)
+		}
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	grID, err := nextID(stub, "GRN")
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := GoodsReceipt{ID: grID, PurchaseOrder: po.ID, NGO: po.NGO, Lines: lines, ReceivedAt: now}
+	receiptBytes, err := json.Marshal(&receipt)
+	if err != nil {
+		return nil, errors.New("Error marshalling goods receipt " + grID)
+	}
+	err = stub.PutState(goodsReceiptPrefix+grID, receiptBytes)
+	if err != nil {
+		return nil, errors.New("Error writing goods receipt " + grID)
+	}
+	err = putPurchaseOrder(stub, po)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Goods receipt " + grID + " recorded ====***====================")
+	return []byte(grID), nil
+}
+
+// setMatchTolerance lets an admin loosen the three-way match
+// args: admin, quantity tolerance (%), price tolerance (%), amount tolerance
+func (t *SimpleChaincode) setMatchTolerance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting match tolerance.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, quantity tolerance, price tolerance and amount tolerance")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set match tolerance for " + args[0])
+	}
+
+	var tolerance MatchTolerance
+	values := []*float64{&tolerance.QuantityPct, &tolerance.PricePct, &tolerance.Amount}
+	for i, value := range values {
+		*value, err = strconv.ParseFloat(args[i+1], 64)
+		if err != nil || *value < 0 {
+			return nil, errors.New("Invalid tolerance value " + args[i+1])
+		}
+	}
+
+	toleranceBytes, err := json.Marshal(&tolerance)
+	if err != nil {
+		return nil, errors.New("Error marshalling match tolerance")
+	}
+	err = stub.PutState(matchToleranceKey, toleranceBytes)
+	if err != nil {
+		return nil, errors.New("Error writing match tolerance")
+	}
+	return nil, nil
+}
+
+//===========================end============purchase orders=================================================
+
+//===========================start============three-way match=================================================
+// threeWayMatch compares an invoice against its purchase order and the goods received so far
+// and returns a description of every disagreement. An empty result means the invoice can be paid.
+func threeWayMatch(stub shim.ChaincodeStubInterface, invoice Invoice) ([]string, error) {
+	mismatches := []string{}
+	if invoice.PurchaseOrder == "" {
+		return append(mismatches, "Invoice "+invoice.ID+" does not reference a purchase order"), nil
+	}
+
+	po, err := getPurchaseOrder(stub, invoice.PurchaseOrder)
+	if err != nil {
+		return nil, err
+	}
+	tolerance, err := getMatchTolerance(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if po.Status != purchaseOrderOpen {
+		mismatches = append(mismatches, "Purchase order "+po.ID+" is "+po.Status)
+	}
+	if po.Vendor != invoice.Vendor || po.NGO != invoice.NGO {
+		mismatches = append(mismatches, "Purchase order "+po.ID+" is between "+po.NGO+" and "+po.Vendor)
+	}
+
+	// Lines for the same item are added up, otherwise each would pass the quantity checks on its own
+	invoiced := map[string]float64{}
+	for _, line := range invoice.LineItems {
+		invoiced[line.Description] += line.Quantity
+	}
+	checked := map[string]bool{}
+
+	var expected float64
+	for _, line := range invoice.LineItems {
+		var poLine *PurchaseOrderLine
+		for i := range po.Lines {
+			if po.Lines[i].Description == line.Description {
+				poLine = &po.Lines[i]
+				break
+			}
+		}
+		if poLine == nil {
+			mismatches = append(mismatches, "Line "+line.Description+" is not on purchase order "+po.ID)
+			continue
+		}
+
+		qty := poLine.InvoicedQuantity + invoiced[line.Description]
+		if !checked[line.Description] {
+			checked[line.Description] = true
+			if qty > poLine.Quantity*(1+tolerance.QuantityPct/100)+1e-9 {
+				mismatches = append(mismatches, "Line "+line.Description+" invoices "+formatAmount(qty)+" of "+formatAmount(poLine.Quantity)+" ordered")
+			}
+			if qty > poLine.ReceivedQuantity*(1+tolerance.QuantityPct/100)+1e-9 {
+				mismatches = append(mismatches, "Line "+line.Description+" invoices "+formatAmount(qty)+" of "+formatAmount(poLine.ReceivedQuantity)+" received")
+			}
+		}
+		if math.Abs(line.UnitPrice-poLine.UnitPrice) > poLine.UnitPrice*tolerance.PricePct/100+1e-9 {
+			mismatches = append(mismatches, "Line "+line.Description+" is priced "+formatAmount(line.UnitPrice)+" against "+formatAmount(poLine.UnitPrice)+" ordered")
+		}
+		expected += line.Quantity * poLine.UnitPrice
+	}
+
+	if math.Abs(invoice.Amount-expected) > tolerance.Amount+0.005 {
+		mismatches = append(mismatches, "Invoice amount "+formatAmount(invoice.Amount)+" against "+formatAmount(expected)+" at purchase order prices")
+	}
+	return mismatches, nil
+}
+
+// applyInvoiceToPurchaseOrder books a paid invoice's quantities against its purchase order
+// and closes the order once every line is fully invoiced
+func applyInvoiceToPurchaseOrder(stub shim.ChaincodeStubInterface, invoice Invoice) error {
+	po, err := getPurchaseOrder(stub, invoice.PurchaseOrder)
+	if err != nil {
+		return err
+	}
+
+	closed := true
+	for i := range po.Lines {
+		for _, line := range invoice.LineItems {
+			if line.Description == po.Lines[i].Description {
+				po.Lines[i].InvoicedQuantity += line.Quantity
+			}
+		}
+		if po.Lines[i].InvoicedQuantity < po.Lines[i].Quantity {
+			closed = false
+		}
+	}
+	if closed {
+		po.Status = purchaseOrderClosed
+	}
+	return putPurchaseOrder(stub, po)
+}
+
+func formatAmount(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// getMatchReport lists the open invoices of a vendor or NGO that fail the three-way match
+// args: account
+func getMatchReport(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	var invoices []Invoice
+	err := scanPrefix(stub, invoicePrefix, func(key string, value []byte) error {
+		var invoice Invoice
+		err := json.Unmarshal(value, &invoice)
+		if err != nil {
+			return errors.New("Error unmarshalling invoice " + key)
+		}
+		if invoice.Status == invoiceOpen && (invoice.Vendor == args[0] || invoice.NGO == args[0]) {
+			invoices = append(invoices, invoice)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := []MatchResult{}
+	for _, invoice := range invoices {
+		mismatches, err := threeWayMatch(stub, invoice)
+		if err != nil {
+			return nil, err
+		}
+		if len(mismatches) > 0 {
+			report = append(report, MatchResult{Invoice: invoice.ID, PurchaseOrder: invoice.PurchaseOrder, Mismatches: mismatches})
+		}
+	}
+
+	return json.Marshal(report)
+}
+
+//===========================end============three-way match=================================================
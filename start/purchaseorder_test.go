@@ -0,0 +1,55 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestPayInvoiceAfterGoodsReceived(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	po := string(s.mustInvoke("relief", "createPurchaseOrder", "relief", "tentco", `[{"description":"tents","quantity":4,"unitPrice":50}]`))
+	id := string(s.mustInvoke("tentco", "submitInvoice", "tentco", "relief", "200", `[{"description":"tents","quantity":4,"unitPrice":50}]`, "2000000000", "hash", po))
+	s.mustInvoke("relief", "recordGoodsReceipt", "relief", po, `[{"description":"tents","quantity":4}]`)
+
+	s.mustInvoke("relief", "payInvoice", "relief", id)
+	if s.balance("tentco") != 200 {
+		t.Errorf("tentco was paid %v for four tents, want 200", s.balance("tentco"))
+	}
+}
+
+func TestPayInvoiceRefusesShortDelivery(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	po := string(s.mustInvoke("relief", "createPurchaseOrder", "relief", "tentco", `[{"description":"tents","quantity":4,"unitPrice":50}]`))
+	id := string(s.mustInvoke("tentco", "submitInvoice", "tentco", "relief", "200", `[{"description":"tents","quantity":4,"unitPrice":50}]`, "2000000000", "hash", po))
+	s.mustInvoke("relief", "recordGoodsReceipt", "relief", po, `[{"description":"tents","quantity":3}]`)
+
+	s.mustFail("relief", "payInvoice", "relief", id)
+	s.mustFail("relief", "setMatchTolerance", "relief", "50", "0", "0")
+	if s.balance("tentco") != 0 {
+		t.Errorf("tentco was paid for tents that did not arrive")
+	}
+
+	s.mustInvoke("root", "setMatchTolerance", "root", "50", "0", "0")
+	s.mustInvoke("relief", "payInvoice", "relief", id)
+	if s.balance("tentco") != 200 {
+		t.Errorf("tentco was paid %v within tolerance, want 200", s.balance("tentco"))
+	}
+}
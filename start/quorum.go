@@ -0,0 +1,418 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========mint proposal records===============
+var mintQuorumKey = "cfg:mintQuorum"
+var proposalPrefix = "proposal:"
+var recentMintPrefix = "mintrecent:"
+
+// mintWindow is how far back direct mints count towards the quorum threshold (24h, in ms)
+const mintWindow int64 = 24 * 60 * 60 * 1000
+
+const (
+	proposalMint   = "MINT"
+	proposalConfig = "CONFIG"
+	proposalAdmin  = "ADMIN"
+
+	proposalPending   = "PENDING"
+	proposalApplied   = "APPLIED"
+	proposalCancelled = "CANCELLED"
+)
+
+// MintQuorum makes mints wait for RequiredApprovals distinct admins once they would take the
+// currency's direct mints over the last mintWindow above Threshold, so splitting a mint
+// doesn't get round the quorum.
+// Proposals that aren't approved within TTL milliseconds expire.
+type MintQuorum struct {
+	Threshold         float64 `json:"threshold"`
+	RequiredApprovals int     `json:"requiredApprovals"`
+	TTL               int64   `json:"ttl"`
+}
+
+// Proposal is a pending mint, a pending change to the quorum itself or a new admin waiting
+// to join the admin set
+type Proposal struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Proposer  string      `json:"proposer"`
+	Account   string      `json:"account,omitempty"`
+	Amount    float64     `json:"amount,omitempty"`
+	Currency  string      `json:"currency,omitempty"`
+	Quorum    *MintQuorum `json:"quorum,omitempty"`
+	Approvals []string    `json:"approvals"`
+	Status    string      `json:"status"`
+	CreatedAt int64       `json:"createdAt"`
+	ExpiresAt int64       `json:"expiresAt"`
+}
+
+//============end==========mint proposal records===============
+
+// getMintQuorum returns the configured quorum, or nil when every mint applies straight away
+func getMintQuorum(stub shim.ChaincodeStubInterface) (*MintQuorum, error) {
+	quorumBytes, err := stub.GetState(mintQuorumKey)
+	if err != nil {
+		return nil, errors.New("Error reading mint quorum")
+	}
+	if len(quorumBytes) == 0 {
+		return nil, nil
+	}
+	var quorum MintQuorum
+	err = json.Unmarshal(quorumBytes, &quorum)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling mint quorum")
+	}
+	return &quorum, nil
+}
+
+func putMintQuorum(stub shim.ChaincodeStubInterface, quorum MintQuorum) error {
+	quorumBytes, err := json.Marshal(&quorum)
+	if err != nil {
+		return errors.New("Error marshalling mint quorum")
+	}
+	err = stub.PutState(mintQuorumKey, quorumBytes)
+	if err != nil {
+		return errors.New("Error writing mint quorum")
+	}
+	return nil
+}
+
+func getProposal(stub shim.ChaincodeStubInterface, proposalID string) (Proposal, error) {
+	var proposal Proposal
+	proposalBytes, err := stub.GetState(proposalPrefix + proposalID)
+	if err != nil || len(proposalBytes) == 0 {
+		fmt.Println("Proposal not found " + proposalID)
+		return proposal, errors.New("Proposal not found " + proposalID)
+	}
+
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		fmt.Println("Error unmarshalling proposal " + proposalID + "\n err:" + err.Error())
+		return proposal, errors.New("Error unmarshalling proposal " + proposalID)
+	}
+	return proposal, nil
+}
+
+func putProposal(stub shim.ChaincodeStubInterface, proposal Proposal) error {
+	proposalBytes, err := json.Marshal(&proposal)
+	if err != nil {
+		fmt.Println("Error marshalling proposal " + proposal.ID)
+		return errors.New("Error marshalling proposal " + proposal.ID)
+	}
+	err = stub.PutState(proposalPrefix+proposal.ID, proposalBytes)
+	if err != nil {
+		fmt.Println("Error writing proposal " + proposal.ID)
+		return errors.New("Error writing proposal " + proposal.ID)
+	}
+	return nil
+}
+
+// countAdmins returns the size of the admin set, the N of an M-of-N quorum. ADMIN accounts
+// outside the set don't count.
+func countAdmins(stub shim.ChaincodeStubInterface) (int, error) {
+	admins, err := getAdmins(stub)
+	return len(admins), err
+}
+
+// createProposal stores a new proposal carrying the proposer's own approval
+func createProposal(stub shim.ChaincodeStubInterface, quorum MintQuorum, proposal Proposal) ([]byte, error) {
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	proposal.ID, err = nextID(stub, "PRP")
+	if err != nil {
+		return nil, err
+	}
+	proposal.Approvals = []string{proposal.Proposer}
+	proposal.Status = proposalPending
+	proposal.CreatedAt = now
+	proposal.ExpiresAt = now + quorum.TTL
+	err = putProposal(stub, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Proposal " + proposal.ID + " awaiting approval ====***====================")
+	return []byte(proposal.ID), nil
+}
+
+// requestAdmin adds an admin straight away when no quorum is needed and otherwise opens a
+// proposal for the quorum to approve, returning its id. Without this one admin could open
+// enough admin accounts to approve its own proposals.
+func requestAdmin(stub shim.ChaincodeStubInterface, admin string, accountID string) ([]byte, error) {
+	quorum, err := getMintQuorum(stub)
+	if err != nil {
+		return nil, err
+	}
+	if quorum == nil || quorum.RequiredApprovals <= 1 {
+		return openAdminAccount(stub, accountID)
+	}
+	return createProposal(stub, *quorum, Proposal{Type: proposalAdmin, Proposer: admin, Account: accountID})
+}
+
+// requestMint mints straight away while the currency's recent direct mints stay within the
+// quorum threshold and otherwise opens a proposal, returning its id.
+func requestMint(stub shim.ChaincodeStubInterface, admin string, accountID string, amount float64, currency string) ([]byte, error) {
+	quorum, err := getMintQuorum(stub)
+	if err != nil {
+		return nil, err
+	}
+	if quorum == nil || quorum.RequiredApprovals <= 1 {
+		return nil, mintFunds(stub, admin, accountID, amount, currency)
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	recent, err := getRecentMints(stub, currency)
+	if err != nil {
+		return nil, err
+	}
+	kept := []RecentTransfer{}
+	total := amount
+	for _, previous := range recent {
+		if previous.Time > now-mintWindow {
+			kept = append(kept, previous)
+			total += previous.Amount
+		}
+	}
+	if total > quorum.Threshold {
+		return createProposal(stub, *quorum, Proposal{Type: proposalMint, Proposer: admin, Account: accountID, Amount: amount, Currency: currency})
+	}
+	err = putRecentMints(stub, currency, append(kept, RecentTransfer{Time: now, Amount: amount}))
+	if err != nil {
+		return nil, err
+	}
+	return nil, mintFunds(stub, admin, accountID, amount, currency)
+}
+
+// getRecentMints returns the direct mints of a currency still counting towards the threshold
+func getRecentMints(stub shim.ChaincodeStubInterface, currency string) ([]RecentTransfer, error) {
+	var recent []RecentTransfer
+	recentBytes, err := stub.GetState(recentMintPrefix + currency)
+	if err != nil {
+		return nil, errors.New("Error reading recent mints of " + currency)
+	}
+	if len(recentBytes) == 0 {
+		return recent, nil
+	}
+	err = json.Unmarshal(recentBytes, &recent)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling recent mints of " + currency)
+	}
+	return recent, nil
+}
+
+func putRecentMints(stub shim.ChaincodeStubInterface, currency string, recent []RecentTransfer) error {
+	recentBytes, err := json.Marshal(recent)
+	if err != nil {
+		return errors.New("Error marshalling recent mints of " + currency)
+	}
+	err = stub.PutState(recentMintPrefix+currency, recentBytes)
+	if err != nil {
+		return errors.New("Error writing recent mints of " + currency)
+	}
+	return nil
+}
+
+// applyProposal carries out a proposal that reached its quorum
+func applyProposal(stub shim.ChaincodeStubInterface, proposal Proposal) error {
+	if proposal.Type == proposalMint {
+		currency := proposal.Currency
+		if currency == "" {
+			currency = baseCurrency
+		}
+		return mintFunds(stub, proposal.Proposer, proposal.Account, proposal.Amount, currency)
+	}
+	if proposal.Type == proposalAdmin {
+		_, err := openAdminAccount(stub, proposal.Account)
+		return err
+	}
+	return putMintQuorum(stub, *proposal.Quorum)
+}
+
+//===========================start============mint proposal functions=================================================
+// setMintQuorum configures the mint quorum. The first configuration applies straight away,
+// later changes are proposals that need the current quorum to approve them.
+// args: admin, threshold, required approvals, proposal ttl (ms)
+func (t *SimpleChaincode) setMintQuorum(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting mint quorum.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, threshold, required approvals and ttl")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set mint quorum for " + args[0])
+	}
+
+	var quorum MintQuorum
+	quorum.Threshold, err = strconv.ParseFloat(args[1], 64)
+	if err != nil || quorum.Threshold < 0 {
+		return nil, errors.New("Invalid threshold " + args[1])
+	}
+	quorum.RequiredApprovals, err = strconv.Atoi(args[2])
+	if err != nil || quorum.RequiredApprovals < 1 {
+		return nil, errors.New("Invalid required approvals " + args[2])
+	}
+	quorum.TTL, err = strconv.ParseInt(args[3], 10, 64)
+	if err != nil || quorum.TTL <= 0 {
+		return nil, errors.New("Invalid ttl " + args[3])
+	}
+
+	admins, err := countAdmins(stub)
+	if err != nil {
+		return nil, err
+	}
+	if quorum.RequiredApprovals > admins {
+		return nil, errors.New("Only " + strconv.Itoa(admins) + " admins exist to approve mints")
+	}
+
+	current, err := getMintQuorum(stub)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.RequiredApprovals <= 1 {
+		return nil, putMintQuorum(stub, quorum)
+	}
+	return createProposal(stub, *current, Proposal{Type: proposalConfig, Proposer: args[0], Quorum: &quorum})
+}
+
+// approveProposal adds an admin's approval and applies the proposal once it has enough. The
+// admin has to be the caller, so each approval comes from a different admin's user.
+// args: admin, proposal id
+func (t *SimpleChaincode) approveProposal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Approving proposal.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin and proposal id")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to approve proposal for " + args[0])
+	}
+	proposal, err := getProposal(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Status != proposalPending {
+		return nil, errors.New("Proposal " + proposal.ID + " is " + proposal.Status)
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now >= proposal.ExpiresAt {
+		return nil, errors.New("Proposal " + proposal.ID + " has expired")
+	}
+	if listContains(proposal.Approvals, args[0]) {
+		return nil, errors.New("Proposal " + proposal.ID + " is already approved by " + args[0])
+	}
+
+	quorum, err := getMintQuorum(stub)
+	if err != nil {
+		return nil, err
+	}
+	proposal.Approvals = append(proposal.Approvals, args[0])
+	if quorum == nil || len(proposal.Approvals) >= quorum.RequiredApprovals {
+		err = applyProposal(stub, proposal)
+		if err != nil {
+			return nil, err
+		}
+		proposal.Status = proposalApplied
+		fmt.Println("==================***=== Proposal " + proposal.ID + " applied ====***====================")
+	}
+
+	return nil, putProposal(stub, proposal)
+}
+
+// cancelProposal withdraws a pending proposal. Only the admin who proposed it can cancel it.
+// args: admin, proposal id
+func (t *SimpleChaincode) cancelProposal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Cancelling proposal.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin and proposal id")
+	}
+
+	err := checkCaller(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	proposal, err := getProposal(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Proposer != args[0] {
+		return nil, errors.New("Proposal " + proposal.ID + " was not proposed by " + args[0])
+	}
+	if proposal.Status != proposalPending {
+		return nil, errors.New("Proposal " + proposal.ID + " is " + proposal.Status)
+	}
+
+	proposal.Status = proposalCancelled
+	return nil, putProposal(stub, proposal)
+}
+
+//===========================end============mint proposal functions=================================================
+
+//===========================start============mint proposal queries=================================================
+// getPendingProposals lists proposals still waiting for approvals at the given time
+// args: as of (ms)
+func getPendingProposals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting as of time")
+	}
+
+	asOf, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid as of time " + args[0])
+	}
+
+	proposals := []Proposal{}
+	err = scanPrefix(stub, proposalPrefix, func(key string, value []byte) error {
+		var proposal Proposal
+		err := json.Unmarshal(value, &proposal)
+		if err != nil {
+			return errors.New("Error unmarshalling proposal " + key)
+		}
+		if proposal.Status == proposalPending && asOf < proposal.ExpiresAt {
+			proposals = append(proposals, proposal)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(proposals)
+}
+
+//===========================end============mint proposal queries=================================================
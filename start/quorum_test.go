@@ -0,0 +1,55 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestLargeMintWaitsForQuorum(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("treasurer", "ADMIN", "0")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "setMintQuorum", "root", "100", "2", "600000")
+
+	id := string(s.mustInvoke("root", "mint", "root", "relief", "500"))
+	if id == "" || s.balance("relief") != 0 {
+		t.Fatalf("mint of 500 over a 100 threshold was not held for approval")
+	}
+	s.mustInvoke("treasurer", "approveProposal", "treasurer", id)
+	if s.balance("relief") != 500 {
+		t.Errorf("approved mint credited %v, want 500", s.balance("relief"))
+	}
+}
+
+func TestApproveProposalRefusesProposerAndImpostors(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("treasurer", "ADMIN", "0")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "setMintQuorum", "root", "100", "2", "600000")
+	id := string(s.mustInvoke("root", "mint", "root", "relief", "500"))
+
+	s.mustFail("root", "approveProposal", "root", id)
+	s.mustFail("root", "approveProposal", "treasurer", id)
+	s.mustFail("relief", "approveProposal", "relief", id)
+	s.mustInvoke("root", "mint", "root", "relief", "60")
+	if s.mustInvoke("root", "mint", "root", "relief", "60") == nil {
+		t.Errorf("two mints of 60 in a day slipped under the 100 threshold")
+	}
+	if s.balance("relief") != 60 {
+		t.Errorf("relief holds %v without quorum, want 60", s.balance("relief"))
+	}
+}
@@ -0,0 +1,283 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========donation receipt records===============
+var donationReceiptPrefix = "receipt:"
+var receiptIndexPrefix = "receiptidx:"
+
+// NgoRegistration is what a donation receipt shows about the NGO that issued it
+type NgoRegistration struct {
+	LegalName          string `json:"legalName"`
+	RegistrationNumber string `json:"registrationNumber"`
+	Country            string `json:"country,omitempty"`
+}
+
+// DonationReceipt is issued for every payment from a CORPORATE into an NGO. Numbers run in
+// sequence per NGO. Refunds of the payment are deducted as Refunded, and a receipt whose
+// payment is refunded in full is void.
+type DonationReceipt struct {
+	Number       string          `json:"number"`
+	NGO          string          `json:"ngo"`
+	Registration NgoRegistration `json:"registration"`
+	Donor        string          `json:"donor"`
+	Amount       float64         `json:"amount"`
+	Currency     string          `json:"currency"`
+	Date         int64           `json:"date"`
+	TaxYear      int             `json:"taxYear"`
+	Transfer     string          `json:"transfer"`
+	Refunded     float64         `json:"refunded,omitempty"`
+	Voided       bool            `json:"voided,omitempty"`
+}
+
+type DonationTotal struct {
+	NGO      string  `json:"ngo"`
+	Currency string  `json:"currency"`
+	Receipts int     `json:"receipts"`
+	Amount   float64 `json:"amount"`
+}
+
+type DonationSummary struct {
+	Donor   string             `json:"donor"`
+	TaxYear int                `json:"taxYear"`
+	ByNGO   []DonationTotal    `json:"byNgo"`
+	Totals  map[string]float64 `json:"totals"`
+}
+
+//============end==========donation receipt records===============
+
+// getNgoRegistration reads an NGO's registration from its profile
+func getNgoRegistration(stub shim.ChaincodeStubInterface, ngo string) (NgoRegistration, error) {
+	profile, err := getProfile(stub, ngo)
+	if err != nil || profile == nil {
+		return NgoRegistration{}, err
+	}
+	return NgoRegistration{LegalName: profile.LegalName, RegistrationNumber: profile.RegistrationNumber, Country: profile.Country}, nil
+}
+
+// taxYear is the UTC calendar year of a millisecond timestamp
+func taxYear(ms int64) int {
+	return time.Unix(ms/millisPerSecond, 0).UTC().Year()
+}
+
+// issueDonationReceipt records a receipt when a transfer is a CORPORATE paying an NGO
+func issueDonationReceipt(stub shim.ChaincodeStubInterface, transfer Transfer) error {
+	donor, err := GetCompany(transfer.From, stub)
+	if err != nil {
+		return err
+	}
+	ngo, err := GetCompany(transfer.To, stub)
+	if err != nil {
+		return err
+	}
+	if accountType(donor) != "CORPORATE" || accountType(ngo) != "NGO" {
+		return nil
+	}
+
+	registration, err := getNgoRegistration(stub, ngo.ID)
+	if err != nil {
+		return err
+	}
+	seq, err := nextSequence(stub, "RCPT:"+ngo.ID)
+	if err != nil {
+		return err
+	}
+	receipt := DonationReceipt{
+		Number:       fmt.Sprintf("%s-%08d", ngo.ID, seq),
+		NGO:          ngo.ID,
+		Registration: registration,
+		Donor:        donor.ID,
+		Amount:       transfer.Amount,
+		Currency:     transferCurrency(transfer),
+		Date:         transfer.Time,
+		TaxYear:      taxYear(transfer.Time),
+		Transfer:     transfer.ID,
+	}
+
+	err = putDonationReceipt(stub, receipt)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(receiptIndexPrefix+transfer.ID, []byte(receipt.Number))
+	if err != nil {
+		return errors.New("Error indexing donation receipt " + receipt.Number)
+	}
+
+	fmt.Println("==================***=== Donation receipt " + receipt.Number + " issued to " + donor.ID + " ====***====================")
+	return nil
+}
+
+func putDonationReceipt(stub shim.ChaincodeStubInterface, receipt DonationReceipt) error {
+	receiptBytes, err := json.Marshal(&receipt)
+	if err != nil {
+		return errors.New("Error marshalling donation receipt " + receipt.Number)
+	}
+	err = stub.PutState(donationReceiptPrefix+receipt.Number, receiptBytes)
+	if err != nil {
+		return errors.New("Error writing donation receipt " + receipt.Number)
+	}
+	return nil
+}
+
+// refundDonationReceipt deducts a refund from the receipt issued for a transfer, if there
+// is one, voiding the receipt once the whole donation is refunded
+func refundDonationReceipt(stub shim.ChaincodeStubInterface, transferID string, amount float64) error {
+	number, err := stub.GetState(receiptIndexPrefix + transferID)
+	if err != nil {
+		return errors.New("Error reading the donation receipt of " + transferID)
+	}
+	if len(number) == 0 {
+		return nil
+	}
+	var receipt DonationReceipt
+	receiptBytes, err := stub.GetState(donationReceiptPrefix + string(number))
+	if err != nil || len(receiptBytes) == 0 {
+		return errors.New("Donation receipt not found " + string(number))
+	}
+	err = json.Unmarshal(receiptBytes, &receipt)
+	if err != nil {
+		return errors.New("Error unmarshalling donation receipt " + string(number))
+	}
+
+	receipt.Refunded += amount
+	if receipt.Refunded > receipt.Amount-0.005 {
+		receipt.Voided = true
+		fmt.Println("==================***=== Donation receipt " + receipt.Number + " voided ====***====================")
+	}
+	return putDonationReceipt(stub, receipt)
+}
+
+// donorReceipts returns a donor's receipts for a tax year
+func donorReceipts(stub shim.ChaincodeStubInterface, donor string, year int) ([]DonationReceipt, error) {
+	receipts := []DonationReceipt{}
+	err := scanPrefix(stub, donationReceiptPrefix, func(key string, value []byte) error {
+		var receipt DonationReceipt
+		err := json.Unmarshal(value, &receipt)
+		if err != nil {
+			return errors.New("Error unmarshalling donation receipt " + key)
+		}
+		if receipt.Donor == donor && receipt.TaxYear == year {
+			receipts = append(receipts, receipt)
+		}
+		return nil
+	})
+	return receipts, err
+}
+
+//===========================start============donation receipt functions=================================================
+// setNgoRegistration records the registration details printed on an NGO's donation receipts
+// on the NGO's profile
+// args: admin, ngo, legal name, registration number, country
+func (t *SimpleChaincode) setNgoRegistration(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting NGO registration.=========================")
+
+	if len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, ngo, legal name, registration number and country")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set NGO registration for " + args[0])
+	}
+	_, err = getAccountOfType(stub, args[1], "NGO")
+	if err != nil {
+		return nil, err
+	}
+	if args[2] == "" || args[3] == "" {
+		return nil, errors.New("Legal name and registration number are required")
+	}
+
+	fields := map[string]string{"legalName": args[2], "registrationNumber": args[3], "country": args[4]}
+	return nil, applyProfileUpdate(stub, args[0], args[1], fields)
+}
+
+//===========================end============donation receipt functions=================================================
+
+//===========================start============donation receipt queries=================================================
+// getDonationReceipts lists the receipts issued to a donor in a tax year
+// args: donor, tax year
+func getDonationReceipts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting donor and tax year")
+	}
+
+	year, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid tax year " + args[1])
+	}
+	receipts, err := donorReceipts(stub, args[0], year)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(receipts)
+}
+
+// getDonationSummary totals a donor's receipts for a tax year by NGO and by currency, net
+// of refunds and leaving out void receipts
+// args: donor, tax year
+func getDonationSummary(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting donor and tax year")
+	}
+
+	year, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid tax year " + args[1])
+	}
+	receipts, err := donorReceipts(stub, args[0], year)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := DonationSummary{Donor: args[0], TaxYear: year, ByNGO: []DonationTotal{}, Totals: map[string]float64{}}
+	for _, receipt := range receipts {
+		if receipt.Voided {
+			continue
+		}
+		amount := receipt.Amount - receipt.Refunded
+		summary.Totals[receipt.Currency] += amount
+
+		found := false
+		for i := range summary.ByNGO {
+			if summary.ByNGO[i].NGO == receipt.NGO && summary.ByNGO[i].Currency == receipt.Currency {
+				summary.ByNGO[i].Receipts++
+				summary.ByNGO[i].Amount += amount
+				found = true
+				break
+			}
+		}
+		if !found {
+			summary.ByNGO = append(summary.ByNGO, DonationTotal{NGO: receipt.NGO, Currency: receipt.Currency, Receipts: 1, Amount: amount})
+		}
+	}
+
+	return json.Marshal(summary)
+}
+
+//===========================end============donation receipt queries=================================================
@@ -0,0 +1,62 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func donationReceipts(s *testStub, donor string) []DonationReceipt {
+	s.t.Helper()
+	var receipts []DonationReceipt
+	err := json.Unmarshal(s.query("getDonationReceipts", donor, "1970"), &receipts)
+	if err != nil {
+		s.t.Fatalf("getDonationReceipts: %v", err)
+	}
+	return receipts
+}
+
+func TestDonationIssuesReceipt(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "setNgoRegistration", "root", "relief", "Relief Trust", "REG-1", "KE")
+	s.mustInvoke("acme", "transaction", "acme", "relief", "40", "gift")
+
+	receipts := donationReceipts(s, "acme")
+	if len(receipts) != 1 || receipts[0].Amount != 40 || receipts[0].Registration.RegistrationNumber != "REG-1" {
+		t.Errorf("gift of 40 to relief issued %+v", receipts)
+	}
+}
+
+func TestDonationReceiptRefusesNonDonationsAndVoidsRefunds(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("caterer", "VENDOR", "0")
+	s.mustFail("acme", "setNgoRegistration", "acme", "relief", "Relief Trust", "REG-1", "KE")
+	s.mustInvoke("acme", "transaction", "acme", "caterer", "10", "lunch")
+	id := string(s.mustInvoke("acme", "transaction", "acme", "relief", "40", "gift"))
+	s.mustInvoke("relief", "refund", "relief", id, "40")
+
+	receipts := donationReceipts(s, "acme")
+	if len(receipts) != 1 || !receipts[0].Voided {
+		t.Errorf("refunded gift and vendor payment left receipts %+v", receipts)
+	}
+}
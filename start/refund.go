@@ -0,0 +1,216 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========transfer indexes===============
+// Both indexes map to transfer ids, and keys sort in the order the transfers were made
+var accountTransferPrefix = "accttxfr:"
+var refundIndexPrefix = "refundidx:"
+
+//============end==========transfer indexes===============
+
+// TransferDetail is a transfer together with the refunds made against it
+type TransferDetail struct {
+	Transfer Transfer   `json:"transfer"`
+	Refunds  []Transfer `json:"refunds"`
+}
+
+// indexTransfer adds a newly recorded transfer to the history of both accounts and, for a
+// refund, to the refunds of the transfer it refunds
+func indexTransfer(stub shim.ChaincodeStubInterface, transfer Transfer) error {
+	keys := []string{accountTransferPrefix + transfer.From + ":" + transfer.ID, accountTransferPrefix + transfer.To + ":" + transfer.ID}
+	if transfer.RefundOf != "" {
+		keys = append(keys, refundIndexPrefix+transfer.RefundOf+":"+transfer.ID)
+	}
+	for _, key := range keys {
+		err := stub.PutState(key, []byte(transfer.ID))
+		if err != nil {
+			return errors.New("Error indexing transfer " + transfer.ID)
+		}
+	}
+	return nil
+}
+
+// getIndexedTransfers loads the transfers an index lists under prefix
+func getIndexedTransfers(stub shim.ChaincodeStubInterface, prefix string) ([]Transfer, error) {
+	transfers := []Transfer{}
+	err := scanPrefix(stub, prefix, func(key string, value []byte) error {
+		transfer, err := getTransfer(stub, string(value))
+		if err != nil {
+			return err
+		}
+		transfers = append(transfers, transfer)
+		return nil
+	})
+	return transfers, err
+}
+
+// getRefunds returns the refund transfers that point back at transferID
+func getRefunds(stub shim.ChaincodeStubInterface, transferID string) ([]Transfer, error) {
+	return getIndexedTransfers(stub, refundIndexPrefix+transferID+":")
+}
+
+//===========================start============refund function=================================================
+// refund sends some or all of an earlier transfer back to its payer. Only the payee of the
+// original transfer, or an admin, can refund it, and refunds can never add up to more than
+// the original amount.
+// args: caller, original transfer id, amount[, memo]
+func (t *SimpleChaincode) refund(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Refunding transfer.=========================")
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting caller, transfer id, amount and optional memo")
+	}
+
+	original, err := getTransfer(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if original.RefundOf != "" {
+		return nil, errors.New("Transfer " + original.ID + " is itself a refund")
+	}
+	if original.CreditCurrency != "" {
+		return nil, errors.New("Transfer " + original.ID + " was a currency conversion, convert back instead of refunding")
+	}
+	if args[0] == original.To {
+		err = checkCaller(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = getAccountOfType(stub, args[0], "ADMIN")
+		if err != nil {
+			return nil, errors.New("Only " + original.To + " or an admin can refund transfer " + original.ID)
+		}
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	if original.Refunded+amount > original.Amount+0.005 {
+		fmt.Println("===================Refund would exceed transfer " + original.ID)
+		return nil, errors.New("Only " + strconv.FormatFloat(original.Amount-original.Refunded, 'f', 2, 64) + " of transfer " + original.ID + " is left to refund")
+	}
+
+	err = checkSingleSigner(stub, original.To)
+	if err != nil {
+		return nil, err
+	}
+
+	memo := "Refund of " + original.ID
+	if len(args) == 4 && args[3] != "" {
+		memo = args[3]
+	}
+	refund, err := refundTransfer(stub, original, amount, memo)
+	if err != nil {
+		return nil, err
+	}
+	// Matches paid on a donation go back in proportion to what the donor got back
+	err = reverseDonationMatching(stub, refund, original)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Refund " + refund.ID + " of " + original.ID + " completed ====***====================")
+	return []byte(refund.ID), nil
+}
+
+// refundTransfer pays amount of a transfer back to its payer and deducts it from the transfer
+// and any receipt issued for it. The refund passes the same checks as any payment out of the
+// payee's account, but skips receipts and donation matching, which it takes back instead.
+func refundTransfer(stub shim.ChaincodeStubInterface, original Transfer, amount float64, memo string) (Transfer, error) {
+	checks, err := checkTransfer(stub, original.To, original.From, amount, transferCurrency(original), memo)
+	if err != nil {
+		return Transfer{}, err
+	}
+	refund, err := moveFunds(stub, Transfer{From: original.To, To: original.From, Amount: amount, Currency: original.Currency, Memo: memo, RefundOf: original.ID,
+		Fee: checks.Fee.Fee, FeeCollector: checks.Fee.Collector})
+	if err != nil {
+		return refund, err
+	}
+	err = completeTransfer(stub, refund, checks)
+	if err != nil {
+		return refund, err
+	}
+
+	original.Refunded += amount
+	err = putTransfer(stub, original)
+	if err != nil {
+		return refund, err
+	}
+	err = refundDonationReceipt(stub, original.ID, amount)
+	if err != nil {
+		return refund, err
+	}
+	// A refunded match gives the program its budget back
+	if original.MatchOf != "" {
+		err = restoreMatchBudget(stub, original, amount)
+	}
+	return refund, err
+}
+
+//===========================end============refund function=================================================
+
+//===========================start============transfer queries=================================================
+// getTransferDetail returns a transfer with the refunds made against it
+// args: transfer id
+func getTransferDetail(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting transfer id")
+	}
+
+	transfer, err := getTransfer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := getRefunds(stub, transfer.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(TransferDetail{Transfer: transfer, Refunds: refunds})
+}
+
+// getTransferHistory lists every transfer into or out of an account, oldest first.
+// Refunds carry the id of the transfer they refund in refundOf.
+// args: account
+func getTransferHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	transfers, err := getIndexedTransfers(stub, accountTransferPrefix+args[0]+":")
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(transfers)
+}
+
+//===========================end============transfer queries=================================================
@@ -0,0 +1,60 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRefundLinksToOriginal(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("caterer", "VENDOR", "0")
+	id := string(s.mustInvoke("acme", "transaction", "acme", "caterer", "80", "lunch"))
+
+	refundID := string(s.mustInvoke("caterer", "refund", "caterer", id, "50"))
+	s.mustInvoke("root", "refund", "root", id, "30")
+	var detail TransferDetail
+	err := json.Unmarshal(s.query("getTransferDetail", id), &detail)
+	if err != nil {
+		t.Fatalf("getTransferDetail: %v", err)
+	}
+	if len(detail.Refunds) != 2 || detail.Refunds[0].ID != refundID || detail.Transfer.Refunded != 80 {
+		t.Errorf("transfer detail %+v does not list both refunds", detail)
+	}
+	if s.balance("acme") != 100 || s.balance("caterer") != 0 {
+		t.Errorf("acme holds %v after a full refund, want 100", s.balance("acme"))
+	}
+}
+
+func TestRefundRefusesPayerAndOverRefunds(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("caterer", "VENDOR", "0")
+	id := string(s.mustInvoke("acme", "transaction", "acme", "caterer", "80", "lunch"))
+
+	s.mustFail("acme", "refund", "acme", id, "10")
+	s.mustFail("acme", "refund", "caterer", id, "10")
+	refundID := string(s.mustInvoke("caterer", "refund", "caterer", id, "50"))
+	s.mustFail("caterer", "refund", "caterer", id, "40")
+	s.mustFail("acme", "refund", "acme", refundID, "10")
+	if s.balance("acme") != 70 || s.balance("caterer") != 30 {
+		t.Errorf("refused refunds moved funds: acme %v, caterer %v", s.balance("acme"), s.balance("caterer"))
+	}
+}
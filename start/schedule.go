@@ -0,0 +1,494 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========schedule records===============
+var schedulePrefix = "sched:"
+var scheduleRunPrefix = "schedrun:"
+
+const (
+	scheduleActive    = "ACTIVE"
+	scheduleCompleted = "COMPLETED"
+	scheduleCancelled = "CANCELLED"
+
+	scheduleRunSucceeded = "SUCCEEDED"
+	scheduleRunFailed    = "FAILED"
+	scheduleRunPending   = "PENDING"
+)
+
+// Schedule is a one-off (Interval 0) or recurring transfer. Recurring schedules run every
+// Interval IntervalUnits (DAY, WEEK or MONTH) from Start until End or Count runs, whichever
+// comes first; a zero End or Count means no limit. Skipped counts occurrences that came and
+// went without a processDue call; they aren't made up later. A run the aml rules held stays
+// PendingRun, and the schedule doesn't move on, until a compliance officer decides its flag.
+type Schedule struct {
+	ID           string  `json:"id"`
+	From         string  `json:"from"`
+	To           string  `json:"to"`
+	Amount       float64 `json:"amount"`
+	Memo         string  `json:"memo,omitempty"`
+	Start        int64   `json:"start"`
+	Interval     int     `json:"interval"`
+	IntervalUnit string  `json:"intervalUnit,omitempty"`
+	End          int64   `json:"end,omitempty"`
+	Count        int     `json:"count,omitempty"`
+	Runs         int     `json:"runs"`
+	Failures     int     `json:"failures"`
+	Skipped      int     `json:"skipped,omitempty"`
+	NextRun      int64   `json:"nextRun"`
+	PendingRun   string  `json:"pendingRun,omitempty"`
+	Status       string  `json:"status"`
+}
+
+// ScheduleRun records the outcome of one due occurrence of a schedule
+type ScheduleRun struct {
+	ID       string `json:"id"`
+	Schedule string `json:"schedule"`
+	DueAt    int64  `json:"dueAt"`
+	RanAt    int64  `json:"ranAt"`
+	Status   string `json:"status"`
+	Transfer string `json:"transfer,omitempty"`
+	Flag     string `json:"flag,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+//============end==========schedule records===============
+
+func getSchedule(stub shim.ChaincodeStubInterface, scheduleID string) (Schedule, error) {
+	var schedule Schedule
+	scheduleBytes, err := stub.GetState(schedulePrefix + scheduleID)
+	if err != nil || len(scheduleBytes) == 0 {
+		fmt.Println("Schedule not found " + scheduleID)
+		return schedule, errors.New("Schedule not found " + scheduleID)
+	}
+
+	err = json.Unmarshal(scheduleBytes, &schedule)
+	if err != nil {
+		fmt.Println("Error unmarshalling schedule " + scheduleID + "\n err:" + err.Error())
+		return schedule, errors.New("Error unmarshalling schedule " + scheduleID)
+	}
+	return schedule, nil
+}
+
+func putSchedule(stub shim.ChaincodeStubInterface, schedule Schedule) error {
+	scheduleBytes, err := json.Marshal(&schedule)
+	if err != nil {
+		fmt.Println("Error marshalling schedule " + schedule.ID)
+		return errors.New("Error marshalling schedule " + schedule.ID)
+	}
+	err = stub.PutState(schedulePrefix+schedule.ID, scheduleBytes)
+	if err != nil {
+		fmt.Println("Error writing schedule " + schedule.ID)
+		return errors.New("Error writing schedule " + schedule.ID)
+	}
+	return nil
+}
+
+func getScheduleRun(stub shim.ChaincodeStubInterface, runID string) (ScheduleRun, error) {
+	var run ScheduleRun
+	runBytes, err := stub.GetState(scheduleRunPrefix + runID)
+	if err != nil || len(runBytes) == 0 {
+		return run, errors.New("Schedule run not found " + runID)
+	}
+	err = json.Unmarshal(runBytes, &run)
+	if err != nil {
+		return run, errors.New("Error unmarshalling schedule run " + runID)
+	}
+	return run, nil
+}
+
+// settlePendingRun closes a run the aml rules held once its flag is decided: a cleared flag
+// paid the transfer, a rejected one didn't. It reports false while the flag is still open.
+func settlePendingRun(stub shim.ChaincodeStubInterface, schedule *Schedule, now int64) (ScheduleRun, bool, error) {
+	run, err := getScheduleRun(stub, schedule.PendingRun)
+	if err != nil {
+		return run, false, err
+	}
+	flag, err := getAmlFlag(stub, run.Flag)
+	if err != nil || flag.Status == flagOpen {
+		return run, false, err
+	}
+
+	run.RanAt = now
+	if flag.Status == flagCleared {
+		run.Status = scheduleRunSucceeded
+		run.Transfer = flag.Result
+		schedule.Runs++
+	} else {
+		run.Status = scheduleRunFailed
+		run.Error = "Flag " + flag.ID + " was rejected by " + flag.ClosedBy
+		schedule.Failures++
+	}
+	schedule.PendingRun = ""
+	return run, true, putScheduleRun(stub, run)
+}
+
+func putScheduleRun(stub shim.ChaincodeStubInterface, run ScheduleRun) error {
+	runBytes, err := json.Marshal(&run)
+	if err != nil {
+		return errors.New("Error marshalling schedule run " + run.ID)
+	}
+	err = stub.PutState(scheduleRunPrefix+run.ID, runBytes)
+	if err != nil {
+		return errors.New("Error writing schedule run " + run.ID)
+	}
+	return nil
+}
+
+// addInterval moves a millisecond timestamp on by n days, weeks or calendar months
+func addInterval(ms int64, n int, unit string) int64 {
+	t := time.Unix(ms/millisPerSecond, (ms%millisPerSecond)*nanosPerMillisecond).UTC()
+	switch unit {
+	case "DAY":
+		t = t.AddDate(0, 0, n)
+	case "WEEK":
+		t = t.AddDate(0, 0, 7*n)
+	case "MONTH":
+		t = t.AddDate(0, n, 0)
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// advanceSchedule moves a schedule past the run that was just due and completes it
+// when it has no runs left
+func advanceSchedule(schedule *Schedule) {
+	if schedule.Interval == 0 || (schedule.Count > 0 && schedule.Runs+schedule.Failures >= schedule.Count) {
+		schedule.Status = scheduleCompleted
+		return
+	}
+	schedule.NextRun = addInterval(schedule.NextRun, schedule.Interval, schedule.IntervalUnit)
+	if schedule.End > 0 && schedule.NextRun > schedule.End {
+		schedule.Status = scheduleCompleted
+	}
+}
+
+// skipMissedRuns moves a schedule on to its first occurrence after now, so a processDue call
+// that comes late pays each schedule once instead of once for every occurrence it missed
+func skipMissedRuns(schedule *Schedule, now int64) {
+	for schedule.Status == scheduleActive && schedule.NextRun <= now {
+		schedule.Skipped++
+		advanceSchedule(schedule)
+	}
+}
+
+//===========================start============schedule functions=================================================
+// createSchedule sets up a one-off or recurring transfer from the payer's account
+// args: from, to, amount, memo, start (ms), interval (0 for one-off), interval unit (DAY, WEEK, MONTH), end (ms, 0 for none), count (0 for unlimited)
+func (t *SimpleChaincode) createSchedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Creating schedule.=========================")
+
+	if len(args) != 9 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from, to, amount, memo, start, interval, interval unit, end and count")
+	}
+
+	if args[0] == args[1] {
+		return nil, errors.New("Cannot transfer from " + args[0] + " to itself")
+	}
+	_, err := GetCompany(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+	// Refuse up front what every run would refuse
+	err = checkParties(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = checkPayee(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	start, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid start " + args[4])
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if start < now {
+		return nil, errors.New("Start " + args[4] + " is in the past")
+	}
+	interval, err := strconv.Atoi(args[5])
+	if err != nil || interval < 0 {
+		return nil, errors.New("Invalid interval " + args[5])
+	}
+	unit := args[6]
+	if interval > 0 && unit != "DAY" && unit != "WEEK" && unit != "MONTH" {
+		return nil, errors.New("Invalid interval unit " + unit)
+	}
+	if interval == 0 {
+		unit = ""
+	}
+	end, err := strconv.ParseInt(args[7], 10, 64)
+	if err != nil || (end != 0 && end < start) {
+		return nil, errors.New("Invalid end " + args[7])
+	}
+	count, err := strconv.Atoi(args[8])
+	if err != nil || count < 0 {
+		return nil, errors.New("Invalid count " + args[8])
+	}
+
+	scheduleID, err := nextID(stub, "SCH")
+	if err != nil {
+		return nil, err
+	}
+	schedule := Schedule{
+		ID:           scheduleID,
+		From:         args[0],
+		To:           args[1],
+		Amount:       amount,
+		Memo:         args[3],
+		Start:        start,
+		Interval:     interval,
+		IntervalUnit: unit,
+		End:          end,
+		Count:        count,
+		NextRun:      start,
+		Status:       scheduleActive,
+	}
+	err = putSchedule(stub, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Schedule " + scheduleID + " created ====***====================")
+	return []byte(scheduleID), nil
+}
+
+// cancelSchedule stops a schedule. Only the payer can cancel it.
+// args: from, schedule id
+func (t *SimpleChaincode) cancelSchedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Cancelling schedule.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from and schedule id")
+	}
+
+	schedule, err := getSchedule(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if schedule.From != args[0] {
+		return nil, errors.New("Schedule " + schedule.ID + " does not pay from " + args[0])
+	}
+	if schedule.Status != scheduleActive {
+		return nil, errors.New("Schedule " + schedule.ID + " is " + schedule.Status)
+	}
+
+	schedule.Status = scheduleCancelled
+	return nil, putSchedule(stub, schedule)
+}
+
+// processDue runs every schedule that is due by the transaction time. A schedule that missed
+// several occurrences runs once and moves on to its next future occurrence. Anyone may call it.
+// A run that fails, for example for lack of cash, is recorded and skipped without stopping the others.
+func (t *SimpleChaincode) processDue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Processing due schedules.=========================")
+
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting none")
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []Schedule
+	err = scanPrefix(stub, schedulePrefix, func(key string, value []byte) error {
+		var schedule Schedule
+		err := json.Unmarshal(value, &schedule)
+		if err != nil {
+			return errors.New("Error unmarshalling schedule " + key)
+		}
+		if schedule.Status == scheduleActive && schedule.NextRun <= now {
+			due = append(due, schedule)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []ScheduleRun{}
+	for _, schedule := range due {
+		// A held run is settled before the schedule moves on to its next run
+		if schedule.PendingRun != "" {
+			run, settled, err := settlePendingRun(stub, &schedule, now)
+			if err != nil {
+				return nil, err
+			}
+			if !settled {
+				continue
+			}
+			runs = append(runs, run)
+			advanceSchedule(&schedule)
+			skipMissedRuns(&schedule, now)
+			err = putSchedule(stub, schedule)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		run := ScheduleRun{Schedule: schedule.ID, DueAt: schedule.NextRun, RanAt: now}
+		run.ID, err = nextID(stub, "RUN")
+		if err != nil {
+			return nil, err
+		}
+
+		// A run keeps all of its writes or none, so one that fails part way through doesn't
+		// leave money moved behind a FAILED run
+		runStub := newStateBuffer(stub)
+		run.Transfer, err = transferFunds(runStub, schedule.From, schedule.To, schedule.Amount, schedule.Memo)
+		if hold, ok := err.(*amlHold); ok {
+			// The run waits for a compliance officer rather than failing
+			flagID, err := hold.record(stub, "PROCESS_DUE")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Println("===================Schedule " + schedule.ID + " held as " + string(flagID))
+			run.Status = scheduleRunPending
+			run.Flag = string(flagID)
+			schedule.PendingRun = run.ID
+			err = putScheduleRun(stub, run)
+			if err != nil {
+				return nil, err
+			}
+			runs = append(runs, run)
+			err = putSchedule(stub, schedule)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Println("===================Schedule " + schedule.ID + " failed: " + err.Error())
+			run.Status = scheduleRunFailed
+			run.Error = err.Error()
+			schedule.Failures++
+			if stop, ok := err.(complianceStop); ok {
+				eventID, err := stop.record(stub, "PROCESS_DUE")
+				if err != nil {
+					return nil, err
+				}
+				run.Error += ", recorded as " + string(eventID)
+			}
+		} else {
+			err = runStub.commit()
+			if err != nil {
+				return nil, err
+			}
+			run.Status = scheduleRunSucceeded
+			schedule.Runs++
+		}
+
+		err = putScheduleRun(stub, run)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+		advanceSchedule(&schedule)
+		skipMissedRuns(&schedule, now)
+
+		err = putSchedule(stub, schedule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("==================***=== Processed " + strconv.Itoa(len(runs)) + " scheduled transfers ====***====================")
+	return json.Marshal(runs)
+}
+
+//===========================end============schedule functions=================================================
+
+//===========================start============schedule queries=================================================
+// getSchedules lists the schedules an account pays or receives
+// args: account
+func getSchedules(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	schedules := []Schedule{}
+	err := scanPrefix(stub, schedulePrefix, func(key string, value []byte) error {
+		var schedule Schedule
+		err := json.Unmarshal(value, &schedule)
+		if err != nil {
+			return errors.New("Error unmarshalling schedule " + key)
+		}
+		if schedule.From == args[0] || schedule.To == args[0] {
+			schedules = append(schedules, schedule)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(schedules)
+}
+
+// getScheduleRuns lists every run of a schedule, including failed ones
+// args: schedule id
+func getScheduleRuns(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting schedule id")
+	}
+
+	runs := []ScheduleRun{}
+	err := scanPrefix(stub, scheduleRunPrefix, func(key string, value []byte) error {
+		var run ScheduleRun
+		err := json.Unmarshal(value, &run)
+		if err != nil {
+			return errors.New("Error unmarshalling schedule run " + key)
+		}
+		if run.Schedule == args[0] {
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(runs)
+}
+
+//===========================end============schedule queries=================================================
@@ -0,0 +1,57 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProcessDueRunsRecurringPledge(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "250")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("acme", "createSchedule", "acme", "relief", "100", "pledge", "2000000", "1", "MONTH", "0", "2")
+
+	s.now = 2000000
+	s.mustInvoke("root", "processDue")
+	s.now += 31 * 24 * 60 * 60 * 1000
+	s.mustInvoke("root", "processDue")
+	s.now += 31 * 24 * 60 * 60 * 1000
+	s.mustInvoke("root", "processDue")
+	if s.balance("relief") != 200 || s.balance("acme") != 50 {
+		t.Errorf("two-run pledge paid relief %v, want 200", s.balance("relief"))
+	}
+}
+
+func TestCreateScheduleRefusesBlockedPayeeAndPastStart(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "250")
+	s.openAccount("shellco", "VENDOR", "0")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "addToBlocklist", "root", "ACCOUNT", "shellco", "fraud")
+
+	result := string(s.mustInvoke("acme", "createSchedule", "acme", "shellco", "10", "rent", "2000000", "0", "DAY", "0", "0"))
+	if !strings.HasPrefix(result, "CMP") {
+		t.Errorf("schedule to a blocked payee returned %s, want a compliance record", result)
+	}
+	s.mustFail("acme", "createSchedule", "acme", "relief", "10", "pledge", "500000", "0", "", "0", "0")
+	if schedules := string(s.query("getSchedules", "acme")); schedules != "[]" {
+		t.Errorf("refused schedules were stored: %s", schedules)
+	}
+}
@@ -0,0 +1,340 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========screening records===============
+var blocklistPrefix = "block:"
+var complianceEventPrefix = "compliance:"
+
+const (
+	blockAccount = "ACCOUNT"
+	blockPattern = "PATTERN"
+
+	complianceScreeningHit = "SCREENING_HIT"
+)
+
+// BlockEntry blocks one account ID, or every account whose ID or legal name matches a
+// pattern. Patterns use * and ? wildcards and ignore case.
+type BlockEntry struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Reason  string `json:"reason,omitempty"`
+	AddedBy string `json:"addedBy"`
+	AddedAt int64  `json:"addedAt"`
+}
+
+// ComplianceEvent records an attempt compliance rules stopped. The attempt itself
+// returns the event id instead of an error, so the event stays on the ledger while
+// everything else the attempt wrote is dropped.
+type ComplianceEvent struct {
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Action  string      `json:"action"`
+	Subject string      `json:"subject"`
+	Match   *BlockEntry `json:"match,omitempty"`
+	Parties []string    `json:"parties"`
+	Details string      `json:"details,omitempty"`
+	Time    int64       `json:"time"`
+	TxID    string      `json:"txId"`
+}
+
+//============end==========screening records===============
+
+func blockKey(blockType string, value string) string {
+	if blockType == blockPattern {
+		value = strings.ToLower(value)
+	}
+	return blocklistPrefix + blockType + ":" + value
+}
+
+// screenName checks an account id, and its legal name if it has one, against the blocklist.
+// It returns the matching entry, or nil when the account is clear.
+func screenName(stub shim.ChaincodeStubInterface, accountID string, legalName string) (*BlockEntry, error) {
+	entryBytes, err := stub.GetState(blockKey(blockAccount, accountID))
+	if err != nil {
+		return nil, errors.New("Error reading blocklist for " + accountID)
+	}
+	if len(entryBytes) > 0 {
+		var entry BlockEntry
+		err = json.Unmarshal(entryBytes, &entry)
+		if err != nil {
+			return nil, errors.New("Error unmarshalling blocklist entry for " + accountID)
+		}
+		return &entry, nil
+	}
+
+	var match *BlockEntry
+	err = scanPrefix(stub, blocklistPrefix+blockPattern+":", func(key string, value []byte) error {
+		if match != nil {
+			return nil
+		}
+		var entry BlockEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			return errors.New("Error unmarshalling blocklist entry " + key)
+		}
+		pattern := strings.ToLower(entry.Value)
+		for _, name := range []string{accountID, legalName} {
+			if name == "" {
+				continue
+			}
+			matched, _ := path.Match(pattern, strings.ToLower(name))
+			if matched {
+				match = &entry
+			}
+		}
+		return nil
+	})
+	return match, err
+}
+
+// screenAccount screens an existing account under its id and profile legal name
+func screenAccount(stub shim.ChaincodeStubInterface, accountID string) (*BlockEntry, error) {
+	profile, err := getProfile(stub, accountID)
+	if err != nil {
+		return nil, err
+	}
+	legalName := ""
+	if profile != nil {
+		legalName = profile.LegalName
+	}
+	return screenName(stub, accountID, legalName)
+}
+
+// recordComplianceEvent stores an event and returns its id for the caller to hand back
+func recordComplianceEvent(stub shim.ChaincodeStubInterface, event ComplianceEvent) ([]byte, error) {
+	var err error
+	event.Time, err = txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	event.ID, err = nextID(stub, "CMP")
+	if err != nil {
+		return nil, err
+	}
+	event.TxID = stub.GetTxID()
+
+	eventBytes, err := json.Marshal(&event)
+	if err != nil {
+		return nil, errors.New("Error marshalling compliance event " + event.ID)
+	}
+	err = stub.PutState(complianceEventPrefix+event.ID, eventBytes)
+	if err != nil {
+		return nil, errors.New("Error writing compliance event " + event.ID)
+	}
+
+	fmt.Println("==================***=== " + event.Action + " by " + event.Subject + " stopped, compliance event " + event.ID + " ====***====================")
+	return []byte(event.ID), nil
+}
+
+// complianceStop is an error that stops a transfer for compliance reasons wherever in a
+// function it happens. Invoke drops everything the function wrote and keeps only what
+// record writes, handing back what record returns.
+type complianceStop interface {
+	error
+	record(stub shim.ChaincodeStubInterface, action string) ([]byte, error)
+}
+
+// screeningBlock stops a transfer to or from a blocked account
+type screeningBlock struct {
+	event ComplianceEvent
+}
+
+func (b *screeningBlock) Error() string {
+	return "Transfer stopped, " + b.event.Subject + " is blocked"
+}
+
+func (b *screeningBlock) record(stub shim.ChaincodeStubInterface, action string) ([]byte, error) {
+	event := b.event
+	event.Action = action
+	return recordComplianceEvent(stub, event)
+}
+
+// screenParties returns a screeningBlock if either side of a transfer is blocked
+func screenParties(stub shim.ChaincodeStubInterface, fromID string, toID string) error {
+	for _, party := range []string{fromID, toID} {
+		hit, err := screenAccount(stub, party)
+		if err != nil {
+			return err
+		}
+		if hit != nil {
+			return &screeningBlock{event: ComplianceEvent{Type: complianceScreeningHit, Subject: party, Match: hit, Parties: []string{fromID, toID},
+				Details: "transfer from " + fromID + " to " + toID}}
+		}
+	}
+	return nil
+}
+
+// actionName is the compliance event action for a function, e.g. PAY_INVOICE for payInvoice
+func actionName(function string) string {
+	var action []rune
+	for i, r := range function {
+		if unicode.IsUpper(r) && i > 0 {
+			action = append(action, '_')
+		}
+		action = append(action, unicode.ToUpper(r))
+	}
+	return string(action)
+}
+
+//===========================start============screening functions=================================================
+// addToBlocklist blocks an account id or a name pattern
+// args: admin, ACCOUNT|PATTERN, account id or pattern, reason
+func (t *SimpleChaincode) addToBlocklist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Adding to blocklist.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, ACCOUNT or PATTERN, value and reason")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to add to blocklist for " + args[0])
+	}
+	if args[1] != blockAccount && args[1] != blockPattern {
+		return nil, errors.New("Expecting ACCOUNT or PATTERN, not " + args[1])
+	}
+	if args[2] == "" {
+		return nil, errors.New("Blocklist value can't be empty")
+	}
+	if args[1] == blockPattern {
+		_, err = path.Match(strings.ToLower(args[2]), "")
+		if err != nil {
+			return nil, errors.New("Invalid pattern " + args[2])
+		}
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	entry := BlockEntry{Type: args[1], Value: args[2], Reason: args[3], AddedBy: args[0], AddedAt: now}
+	entryBytes, err := json.Marshal(&entry)
+	if err != nil {
+		return nil, errors.New("Error marshalling blocklist entry " + args[2])
+	}
+	err = stub.PutState(blockKey(entry.Type, entry.Value), entryBytes)
+	if err != nil {
+		return nil, errors.New("Error writing blocklist entry " + args[2])
+	}
+	return nil, nil
+}
+
+// removeFromBlocklist lifts a block
+// args: admin, ACCOUNT|PATTERN, account id or pattern
+func (t *SimpleChaincode) removeFromBlocklist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Removing from blocklist.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, ACCOUNT or PATTERN and value")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to remove from blocklist for " + args[0])
+	}
+	key := blockKey(args[1], args[2])
+	entryBytes, err := stub.GetState(key)
+	if err != nil || len(entryBytes) == 0 {
+		return nil, errors.New("Not on the blocklist " + args[1] + " " + args[2])
+	}
+	err = stub.DelState(key)
+	if err != nil {
+		return nil, errors.New("Error removing blocklist entry " + args[2])
+	}
+	return nil, nil
+}
+
+//===========================end============screening functions=================================================
+
+//===========================start============screening queries=================================================
+// getBlocklist lists every blocked account id and pattern
+func getBlocklist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errors.New("Incorrect number of arguments. Expecting none")
+	}
+
+	entries := []BlockEntry{}
+	err := scanPrefix(stub, blocklistPrefix, func(key string, value []byte) error {
+		var entry BlockEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			return errors.New("Error unmarshalling blocklist entry " + key)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(entries)
+}
+
+// getScreeningHits lists the attempts the blocklist stopped between two times
+// args: from (ms), to (ms)[, account]
+func getScreeningHits(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from time, to time and optional account")
+	}
+
+	fromMs, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid from time " + args[0])
+	}
+	toMs, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid to time " + args[1])
+	}
+
+	events := []ComplianceEvent{}
+	err = scanPrefix(stub, complianceEventPrefix, func(key string, value []byte) error {
+		var event ComplianceEvent
+		err := json.Unmarshal(value, &event)
+		if err != nil {
+			return errors.New("Error unmarshalling compliance event " + key)
+		}
+		if event.Type != complianceScreeningHit || event.Time < fromMs || event.Time >= toMs {
+			return nil
+		}
+		if len(args) == 3 && !listContains(event.Parties, args[2]) {
+			return nil
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(events)
+}
+
+//===========================end============screening queries=================================================
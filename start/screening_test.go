@@ -0,0 +1,57 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPaymentClearsAfterDelisting(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("root", "addToBlocklist", "root", "ACCOUNT", "relief", "mistaken identity")
+	s.mustInvoke("root", "removeFromBlocklist", "root", "ACCOUNT", "relief")
+
+	s.mustInvoke("acme", "transaction", "acme", "relief", "10", "gift")
+	if s.balance("relief") != 10 {
+		t.Errorf("delisted relief received %v, want 10", s.balance("relief"))
+	}
+}
+
+func TestScreeningBlocksListedCounterparties(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("traders", "VENDOR", "0")
+	s.mustFail("acme", "addToBlocklist", "acme", "ACCOUNT", "relief", "competitor")
+	s.mustInvoke("root", "addToBlocklist", "root", "ACCOUNT", "relief", "fraud")
+	s.mustInvoke("root", "addToBlocklist", "root", "PATTERN", "*evil*", "sanctions")
+	s.mustInvoke("root", "updateProfile", "root", "traders", `{"legalName":"Evil Traders"}`)
+
+	for _, payee := range []string{"relief", "traders"} {
+		result := string(s.mustInvoke("acme", "transaction", "acme", payee, "10", "payment"))
+		if !strings.HasPrefix(result, "CMP") || s.balance(payee) != 0 {
+			t.Errorf("payment to listed %s returned %s", payee, result)
+		}
+	}
+	if s.balance("acme") != 100 {
+		t.Errorf("blocked payments took %v from acme", 100-s.balance("acme"))
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========statement records===============
+// StatementLine is one credit or debit to the account, with the balance after it
+type StatementLine struct {
+	Time         int64   `json:"time"`
+	Entry        string  `json:"entry"`
+	Reference    string  `json:"reference"`
+	Type         string  `json:"type"`
+	Counterparty string  `json:"counterparty"`
+	Memo         string  `json:"memo,omitempty"`
+	Credit       float64 `json:"credit,omitempty"`
+	Debit        float64 `json:"debit,omitempty"`
+	Balance      float64 `json:"balance"`
+}
+
+// Statement covers From up to but not including To. JournalBalance is the account's
+// balance from every journal entry to date, which should equal the CurrentBalance
+// held on the account.
+type Statement struct {
+	Account        string          `json:"account"`
+	Currency       string          `json:"currency"`
+	From           int64           `json:"from"`
+	To             int64           `json:"to"`
+	OpeningBalance float64         `json:"openingBalance"`
+	Lines          []StatementLine `json:"lines"`
+	TotalCredits   float64         `json:"totalCredits"`
+	TotalDebits    float64         `json:"totalDebits"`
+	ClosingBalance float64         `json:"closingBalance"`
+	JournalBalance float64         `json:"journalBalance"`
+	CurrentBalance float64         `json:"currentBalance"`
+	Reconciled     bool            `json:"reconciled"`
+}
+
+//============end==========statement records===============
+
+// counterparty names the other side of a journal entry for an account: the other account
+// holder, or the ledger account when money was minted, burnt or converted
+func counterparty(entry JournalEntry, accountID string) string {
+	for _, line := range entry.Lines {
+		if line.Party != "" && line.Party != accountID {
+			return line.Party
+		}
+	}
+	for _, line := range entry.Lines {
+		if line.Account != glAccountBalances {
+			return chartOfAccounts[line.Account]
+		}
+	}
+	return ""
+}
+
+//===========================start============statement queries=================================================
+// getStatement lists an account's credits and debits in a period between its opening and
+// closing balances, and checks the journal agrees with the account's balance
+// args: account, from (ms), to (ms)[, currency]
+func getStatement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account, from time, to time and optional currency")
+	}
+
+	account, err := GetCompany(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+	fromMs, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid from time " + args[1])
+	}
+	toMs, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || toMs < fromMs {
+		return nil, errors.New("Invalid to time " + args[2])
+	}
+	currency, err := currencyArg(stub, args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := Statement{Account: account.ID, Currency: currency, From: fromMs, To: toMs, Lines: []StatementLine{}}
+	err = scanPrefix(stub, journalPrefix, func(key string, value []byte) error {
+		var entry JournalEntry
+		err := json.Unmarshal(value, &entry)
+		if err != nil {
+			return errors.New("Error unmarshalling journal entry " + key)
+		}
+		for _, line := range entry.Lines {
+			if line.Account != glAccountBalances || line.Party != account.ID || line.Currency != currency {
+				continue
+			}
+			// Credits to a balance account increase what the holder has
+			net := line.Credit - line.Debit
+			statement.JournalBalance += net
+			if entry.Time < fromMs {
+				statement.OpeningBalance += net
+			} else if entry.Time < toMs {
+				statement.TotalCredits += line.Credit
+				statement.TotalDebits += line.Debit
+				statement.Lines = append(statement.Lines, StatementLine{
+					Time:         entry.Time,
+					Entry:        entry.ID,
+					Reference:    entry.Reference,
+					Type:         entry.Source,
+					Counterparty: counterparty(entry, account.ID),
+					Memo:         entry.Memo,
+					Credit:       line.Credit,
+					Debit:        line.Debit,
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	running := statement.OpeningBalance
+	for i := range statement.Lines {
+		running += statement.Lines[i].Credit - statement.Lines[i].Debit
+		statement.Lines[i].Balance = running
+	}
+	statement.ClosingBalance = running
+	statement.CurrentBalance = balanceIn(account, currency)
+	statement.Reconciled = math.Abs(statement.JournalBalance-statement.CurrentBalance) < 0.005
+	if !statement.Reconciled {
+		fmt.Println("===================Statement for " + account.ID + " does not reconcile, journal = " + strconv.FormatFloat(statement.JournalBalance, 'f', 2, 64) + " account = " + strconv.FormatFloat(statement.CurrentBalance, 'f', 2, 64))
+	}
+	return json.Marshal(statement)
+}
+
+//===========================end============statement queries=================================================
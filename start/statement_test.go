@@ -0,0 +1,56 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestStatementOpensAndClosesOnPeriod(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.mustInvoke("acme", "transaction", "acme", "relief", "10", "first")
+	from := s.now + 1
+	s.mustInvoke("acme", "transaction", "acme", "relief", "20", "second")
+	s.mustInvoke("relief", "transaction", "relief", "acme", "5", "returned")
+	to := s.now + 1
+	s.mustInvoke("acme", "transaction", "acme", "relief", "1", "later")
+
+	var statement Statement
+	err := json.Unmarshal(s.query("getStatement", "acme", strconv.FormatInt(from, 10), strconv.FormatInt(to, 10)), &statement)
+	if err != nil {
+		t.Fatalf("getStatement: %v", err)
+	}
+	if statement.OpeningBalance != 90 || statement.ClosingBalance != 75 || len(statement.Lines) != 2 || !statement.Reconciled {
+		t.Errorf("statement %+v, want 90 opening, 75 closing over two lines", statement)
+	}
+}
+
+func TestStatementRefusesBackwardPeriodAndUnknownAccount(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	if _, err := s.cc.Query(s, "getStatement", []string{"acme", "2000000", "1000000"}); err == nil {
+		t.Errorf("statement ending before it starts was produced")
+	}
+	if _, err := s.cc.Query(s, "getStatement", []string{"nobody", "0", "2000000"}); err == nil {
+		t.Errorf("statement for an unknown account was produced")
+	}
+}
@@ -0,0 +1,277 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========supply records===============
+var totalSupplyKey = "supply:total"
+var supplyChangePrefix = "supplylog:"
+
+const (
+	supplyMint = "MINT"
+	supplyBurn = "BURN"
+
+	// convertAndTransfer takes money out of one currency's supply and adds it to another's
+	supplyConvertOut = "CONVERT_OUT"
+	supplyConvertIn  = "CONVERT_IN"
+)
+
+// SupplyChange records one mint or burn and the total supply of its currency after it
+type SupplyChange struct {
+	ID       string  `json:"id"`
+	Type     string  `json:"type"`
+	Admin    string  `json:"admin"`
+	Account  string  `json:"account"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency,omitempty"`
+	Supply   float64 `json:"supply"`
+	Time     int64   `json:"time"`
+}
+
+type SupplyCheck struct {
+	Currency       string  `json:"currency"`
+	RecordedSupply float64 `json:"recordedSupply"`
+	AccountTotal   float64 `json:"accountTotal"`
+	Difference     float64 `json:"difference"`
+	Accounts       int     `json:"accounts"`
+	Balanced       bool    `json:"balanced"`
+}
+
+//============end==========supply records===============
+
+// supplyKey is where the total supply of a currency is kept. The base currency keeps the
+// key it had before currencies were added.
+func supplyKey(currency string) string {
+	if currency == baseCurrency {
+		return totalSupplyKey
+	}
+	return totalSupplyKey + ":" + currency
+}
+
+func getTotalSupply(stub shim.ChaincodeStubInterface, currency string) (float64, error) {
+	supplyBytes, err := stub.GetState(supplyKey(currency))
+	if err != nil {
+		return 0, errors.New("Error reading total supply")
+	}
+	if len(supplyBytes) == 0 {
+		return 0, nil
+	}
+	supply, err := strconv.ParseFloat(string(supplyBytes), 64)
+	if err != nil {
+		return 0, errors.New("Error reading total supply")
+	}
+	return supply, nil
+}
+
+// changeSupply adjusts the recorded total supply and logs the mint or burn behind it
+func changeSupply(stub shim.ChaincodeStubInterface, changeType string, admin string, accountID string, amount float64, currency string) error {
+	supply, err := getTotalSupply(stub, currency)
+	if err != nil {
+		return err
+	}
+	if changeType == supplyMint || changeType == supplyConvertIn {
+		supply += amount
+	} else {
+		supply -= amount
+	}
+	err = stub.PutState(supplyKey(currency), []byte(strconv.FormatFloat(supply, 'f', -1, 64)))
+	if err != nil {
+		return errors.New("Error writing total supply")
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return err
+	}
+	changeID, err := nextID(stub, "SUP")
+	if err != nil {
+		return err
+	}
+	change := SupplyChange{ID: changeID, Type: changeType, Admin: admin, Account: accountID, Amount: amount, Currency: currency, Supply: supply, Time: now}
+	changeBytes, err := json.Marshal(&change)
+	if err != nil {
+		return errors.New("Error marshalling supply change " + changeID)
+	}
+	err = stub.PutState(supplyChangePrefix+changeID, changeBytes)
+	if err != nil {
+		return errors.New("Error writing supply change " + changeID)
+	}
+	err = journalSupplyChange(stub, change)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("==================***=== " + changeType + " " + strconv.FormatFloat(amount, 'f', 2, 64) + " " + currency + " for " + accountID + ", supply = " + strconv.FormatFloat(supply, 'f', 2, 64) + " ====***====================")
+	return nil
+}
+
+// mintFunds creates new money in an account. Callers check that admin really is an admin.
+func mintFunds(stub shim.ChaincodeStubInterface, admin string, accountID string, amount float64, currency string) error {
+	err := checkVerified(stub, accountID)
+	if err != nil {
+		return err
+	}
+	account, err := GetCompany(accountID, stub)
+	if err != nil {
+		return err
+	}
+	adjustBalance(&account, currency, amount)
+	err = putAccount(stub, account)
+	if err != nil {
+		return err
+	}
+	return changeSupply(stub, supplyMint, admin, accountID, amount, currency)
+}
+
+// burnFunds destroys money held in an account. Held funds can't be burnt.
+func burnFunds(stub shim.ChaincodeStubInterface, admin string, accountID string, amount float64, currency string) error {
+	account, err := GetCompany(accountID, stub)
+	if err != nil {
+		return err
+	}
+	if spendableIn(account, currency) < amount {
+		fmt.Println("===============The account " + accountID + " doesn't have enough cash to burn")
+		return errors.New("The account " + accountID + " doesn't have enough cash to burn " + strconv.FormatFloat(amount, 'f', 2, 64))
+	}
+	adjustBalance(&account, currency, -amount)
+	err = putAccount(stub, account)
+	if err != nil {
+		return err
+	}
+	return changeSupply(stub, supplyBurn, admin, accountID, amount, currency)
+}
+
+// recordOpeningMint books the opening balance createAccount gave a new account against the supply
+func recordOpeningMint(stub shim.ChaincodeStubInterface, admin string, accountID string, amount float64, currency string) error {
+	if admin == "" || amount == 0 {
+		return nil
+	}
+	err := checkVerified(stub, accountID)
+	if err != nil {
+		return err
+	}
+	return changeSupply(stub, supplyMint, admin, accountID, amount, currency)
+}
+
+// parseSupplyArgs checks the admin, account, amount and currency arguments shared by mint and burn
+func parseSupplyArgs(stub shim.ChaincodeStubInterface, args []string) (float64, string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return 0, "", errors.New("Incorrect number of arguments. Expecting admin, account, amount and optional currency")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		fmt.Println("===================Invalid request")
+		return 0, "", errors.New("Invalid Reuest to update amount for " + args[0])
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return 0, "", err
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount ================")
+		return 0, "", errors.New("Invalid Amount value")
+	}
+	currency, err := currencyArg(stub, args, 3)
+	if err != nil {
+		return 0, "", err
+	}
+	return amount, currency, nil
+}
+
+//===========================start============mint and burn=================================================
+// mint credits new money to an account. Mints above the quorum threshold return a
+// proposal id and only apply once enough admins approve.
+// args: admin, account, amount[, currency]
+func (t *SimpleChaincode) mint(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Minting.=========================")
+
+	amount, currency, err := parseSupplyArgs(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	return requestMint(stub, args[0], args[1], amount, currency)
+}
+
+// burn removes money from an account
+// args: admin, account, amount[, currency]
+func (t *SimpleChaincode) burn(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Burning.=========================")
+
+	amount, currency, err := parseSupplyArgs(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	return nil, burnFunds(stub, args[0], args[1], amount, currency)
+}
+
+//===========================end============mint and burn=================================================
+
+//===========================start============supply queries=================================================
+// checkSupply adds up every account balance in a currency and compares it with the
+// recorded total supply of that currency
+// args: [currency]
+func checkSupply(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting optional currency")
+	}
+	currency, err := currencyArg(stub, args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	supply, err := getTotalSupply(stub, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	check := SupplyCheck{Currency: currency, RecordedSupply: supply}
+	err = scanPrefix(stub, accountPrefix, func(key string, value []byte) error {
+		var account Account
+		err := json.Unmarshal(value, &account)
+		if err != nil {
+			return errors.New("Error unmarshalling account " + key)
+		}
+		check.AccountTotal += balanceIn(account, currency)
+		check.Accounts++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	check.Difference = check.AccountTotal - check.RecordedSupply
+	check.Balanced = math.Abs(check.Difference) < 0.005
+	if !check.Balanced {
+		fmt.Println("===================Supply check failed, difference = " + strconv.FormatFloat(check.Difference, 'f', 2, 64))
+	}
+	return json.Marshal(check)
+}
+
+//===========================end============supply queries=================================================
@@ -0,0 +1,58 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func supplyCheck(s *testStub) SupplyCheck {
+	s.t.Helper()
+	var check SupplyCheck
+	err := json.Unmarshal(s.query("checkSupply"), &check)
+	if err != nil {
+		s.t.Fatalf("checkSupply: %v", err)
+	}
+	return check
+}
+
+func TestMintAndBurnTrackSupply(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "100")
+	s.mustInvoke("root", "mint", "root", "relief", "25")
+	s.mustInvoke("root", "burn", "root", "relief", "5")
+
+	check := supplyCheck(s)
+	if s.balance("relief") != 120 || check.RecordedSupply != 120 || !check.Balanced {
+		t.Errorf("supply after minting 125 and burning 5 is %+v, want 120 balanced", check)
+	}
+}
+
+func TestMintRefusesNonAdmins(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "100")
+	s.mustFail("relief", "mint", "relief", "relief", "25")
+	s.mustFail("relief", "mint", "root", "relief", "25")
+	s.mustFail("relief", "burn", "root", "relief", "25")
+
+	check := supplyCheck(s)
+	if s.balance("relief") != 100 || check.RecordedSupply != 100 {
+		t.Errorf("supply changed to %v without an admin", check.RecordedSupply)
+	}
+}
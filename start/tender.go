@@ -0,0 +1,493 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========tender records===============
+var tenderPrefix = "tender:"
+var bidPrefix = "bid:"
+
+const (
+	tenderOpen      = "OPEN"
+	tenderAwarded   = "AWARDED"
+	tenderNoAward   = "NO_AWARD"
+	tenderSettled   = "SETTLED"
+	tenderCancelled = "CANCELLED"
+)
+
+// Tender is a procurement request published by an NGO. Vendors commit a hash of their bid
+// before CommitDeadline and reveal the amount before RevealDeadline; the lowest revealed bid wins.
+type Tender struct {
+	ID             string  `json:"id"`
+	NGO            string  `json:"ngo"`
+	Title          string  `json:"title"`
+	DocumentHash   string  `json:"documentHash"`
+	CommitDeadline int64   `json:"commitDeadline"`
+	RevealDeadline int64   `json:"revealDeadline"`
+	LockFunds      bool    `json:"lockFunds"`
+	Status         string  `json:"status"`
+	Winner         string  `json:"winner,omitempty"`
+	AwardAmount    float64 `json:"awardAmount,omitempty"`
+	CreatedAt      int64   `json:"createdAt"`
+}
+
+// Bid commitment is the hex SHA-256 of "<amount>:<salt>"
+type Bid struct {
+	Tender      string  `json:"tender"`
+	Vendor      string  `json:"vendor"`
+	Commitment  string  `json:"commitment"`
+	Amount      float64 `json:"amount,omitempty"`
+	Revealed    bool    `json:"revealed"`
+	CommittedAt int64   `json:"committedAt"`
+	RevealedAt  int64   `json:"revealedAt,omitempty"`
+}
+
+type TenderStatus struct {
+	Tender Tender `json:"tender"`
+	Phase  string `json:"phase"`
+	Bids   []Bid  `json:"bids"`
+}
+
+//============end==========tender records===============
+
+func getTender(stub shim.ChaincodeStubInterface, tenderID string) (Tender, error) {
+	var tender Tender
+	tenderBytes, err := stub.GetState(tenderPrefix + tenderID)
+	if err != nil || len(tenderBytes) == 0 {
+		fmt.Println("Tender not found " + tenderID)
+		return tender, errors.New("Tender not found " + tenderID)
+	}
+
+	err = json.Unmarshal(tenderBytes, &tender)
+	if err != nil {
+		fmt.Println("Error unmarshalling tender " + tenderID + "\n err:" + err.Error())
+		return tender, errors.New("Error unmarshalling tender " + tenderID)
+	}
+	return tender, nil
+}
+
+func putTender(stub shim.ChaincodeStubInterface, tender Tender) error {
+	tenderBytes, err := json.Marshal(&tender)
+	if err != nil {
+		fmt.Println("Error marshalling tender " + tender.ID)
+		return errors.New("Error marshalling tender " + tender.ID)
+	}
+	err = stub.PutState(tenderPrefix+tender.ID, tenderBytes)
+	if err != nil {
+		fmt.Println("Error writing tender " + tender.ID)
+		return errors.New("Error writing tender " + tender.ID)
+	}
+	return nil
+}
+
+func putBid(stub shim.ChaincodeStubInterface, bid Bid) error {
+	bidBytes, err := json.Marshal(&bid)
+	if err != nil {
+		return errors.New("Error marshalling bid of " + bid.Vendor + " on " + bid.Tender)
+	}
+	err = stub.PutState(bidPrefix+bid.Tender+":"+bid.Vendor, bidBytes)
+	if err != nil {
+		return errors.New("Error writing bid of " + bid.Vendor + " on " + bid.Tender)
+	}
+	return nil
+}
+
+func getBids(stub shim.ChaincodeStubInterface, tenderID string) ([]Bid, error) {
+	bids := []Bid{}
+	err := scanPrefix(stub, bidPrefix+tenderID+":", func(key string, value []byte) error {
+		var bid Bid
+		err := json.Unmarshal(value, &bid)
+		if err != nil {
+			return errors.New("Error unmarshalling bid " + key)
+		}
+		bids = append(bids, bid)
+		return nil
+	})
+	return bids, err
+}
+
+// tenderPhase works out where an open tender is in its timeline at the given time
+func tenderPhase(tender Tender, now int64) string {
+	if tender.Status != tenderOpen {
+		return tender.Status
+	}
+	if now < tender.CommitDeadline {
+		return "COMMIT"
+	} else if now < tender.RevealDeadline {
+		return "REVEAL"
+	}
+	return "AWAITING_AWARD"
+}
+
+//===========================start============tender functions=================================================
+// createTender publishes a tender for NGO
+// args: ngo, title, document hash, commit deadline (ms), reveal deadline (ms), lock funds on award (true/false)
+func (t *SimpleChaincode) createTender(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Creating tender.=========================")
+
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo, title, document hash, commit deadline, reveal deadline and lock funds")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "NGO")
+	if err != nil {
+		return nil, err
+	}
+	err = checkSingleSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	commitDeadline, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid commit deadline " + args[3])
+	}
+	revealDeadline, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid reveal deadline " + args[4])
+	}
+	lockFunds, err := strconv.ParseBool(args[5])
+	if err != nil {
+		return nil, errors.New("Invalid lock funds flag " + args[5])
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if commitDeadline <= now || revealDeadline <= commitDeadline {
+		return nil, errors.New("Commit deadline must be in the future and before the reveal deadline")
+	}
+
+	tenderID, err := nextID(stub, "TND")
+	if err != nil {
+		return nil, err
+	}
+	tender := Tender{
+		ID:             tenderID,
+		NGO:            args[0],
+		Title:          args[1],
+		DocumentHash:   args[2],
+		CommitDeadline: commitDeadline,
+		RevealDeadline: revealDeadline,
+		LockFunds:      lockFunds,
+		Status:         tenderOpen,
+		CreatedAt:      now,
+	}
+	err = putTender(stub, tender)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Tender " + tenderID + " created ====***====================")
+	return []byte(tenderID), nil
+}
+
+// commitBid stores a vendor's sealed bid, replacing any earlier commitment from the same vendor
+// args: vendor, tender id, commitment (hex sha256 of "<amount>:<salt>")
+func (t *SimpleChaincode) commitBid(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Committing bid.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting vendor, tender id and commitment")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "VENDOR")
+	if err != nil {
+		return nil, err
+	}
+	tender, err := getTender(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if tenderPhase(tender, now) != "COMMIT" {
+		return nil, errors.New("Tender " + tender.ID + " is not accepting bids")
+	}
+	commitment := strings.ToLower(args[2])
+	if _, err := hex.DecodeString(commitment); err != nil || len(commitment) != sha256.Size*2 {
+		return nil, errors.New("Invalid bid commitment " + args[2])
+	}
+
+	err = putBid(stub, Bid{Tender: tender.ID, Vendor: args[0], Commitment: commitment, CommittedAt: now})
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// revealBid opens a committed bid during the reveal window
+// args: vendor, tender id, amount, salt
+func (t *SimpleChaincode) revealBid(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Revealing bid.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting vendor, tender id, amount and salt")
+	}
+
+	tender, err := getTender(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if tenderPhase(tender, now) != "REVEAL" {
+		return nil, errors.New("Tender " + tender.ID + " is not in its reveal window")
+	}
+
+	var bid Bid
+	bidBytes, err := stub.GetState(bidPrefix + tender.ID + ":" + args[0])
+	if err != nil || len(bidBytes) == 0 {
+		return nil, errors.New("No bid from " + args[0] + " on tender " + tender.ID)
+	}
+	err = json.Unmarshal(bidBytes, &bid)
+	if err != nil {
+		return nil, errors.New("Error unmarshalling bid of " + args[0] + " on " + tender.ID)
+	}
+	if bid.Revealed {
+		return nil, errors.New("Bid of " + args[0] + " on " + tender.ID + " is already revealed")
+	}
+
+	hash := sha256.Sum256([]byte(args[2] + ":" + args[3]))
+	if hex.EncodeToString(hash[:]) != bid.Commitment {
+		fmt.Println("===================Bid of " + args[0] + " does not match its commitment")
+		return nil, errors.New("Revealed bid does not match the commitment of " + args[0])
+	}
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0.0 {
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+
+	bid.Amount = amount
+	bid.Revealed = true
+	bid.RevealedAt = now
+	err = putBid(stub, bid)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// awardTender picks the lowest revealed bid once the reveal window has closed. Ties go to the
+// earliest commitment. If the tender locks funds the award amount is held on the NGO account.
+// args: ngo, tender id
+func (t *SimpleChaincode) awardTender(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Awarding tender.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo and tender id")
+	}
+
+	tender, err := getTender(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if tender.NGO != args[0] {
+		return nil, errors.New("Tender " + tender.ID + " was not published by " + args[0])
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if tenderPhase(tender, now) != "AWAITING_AWARD" {
+		return nil, errors.New("Tender " + tender.ID + " can't be awarded yet")
+	}
+
+	bids, err := getBids(stub, tender.ID)
+	if err != nil {
+		return nil, err
+	}
+	var winner *Bid
+	for i := range bids {
+		if !bids[i].Revealed {
+			continue
+		}
+		if winner == nil || bids[i].Amount < winner.Amount ||
+			(bids[i].Amount == winner.Amount && bids[i].CommittedAt < winner.CommittedAt) {
+			winner = &bids[i]
+		}
+	}
+
+	if winner == nil {
+		fmt.Println("===================No valid bids on tender " + tender.ID)
+		tender.Status = tenderNoAward
+		return nil, putTender(stub, tender)
+	}
+
+	if tender.LockFunds {
+		err = placeHold(stub, tender.NGO, winner.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tender.Status = tenderAwarded
+	tender.Winner = winner.Vendor
+	tender.AwardAmount = winner.Amount
+	err = putTender(stub, tender)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Tender " + tender.ID + " awarded to " + winner.Vendor + " ====***====================")
+	return []byte(winner.Vendor), nil
+}
+
+// settleTender pays the winning vendor the award amount, releasing any locked funds first
+// args: ngo, tender id
+func (t *SimpleChaincode) settleTender(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Settling tender.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo and tender id")
+	}
+
+	tender, err := getTender(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if tender.NGO != args[0] {
+		return nil, errors.New("Tender " + tender.ID + " was not published by " + args[0])
+	}
+	if tender.Status != tenderAwarded {
+		return nil, errors.New("Tender " + tender.ID + " is " + tender.Status)
+	}
+
+	if tender.LockFunds {
+		err = releaseHold(stub, tender.NGO, tender.AwardAmount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = transferFunds(stub, tender.NGO, tender.Winner, tender.AwardAmount, "Tender "+tender.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tender.Status = tenderSettled
+	return nil, putTender(stub, tender)
+}
+
+// cancelTender withdraws an open or awarded tender and releases any locked funds
+// args: ngo, tender id
+func (t *SimpleChaincode) cancelTender(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Cancelling tender.=========================")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo and tender id")
+	}
+
+	tender, err := getTender(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if tender.NGO != args[0] {
+		return nil, errors.New("Tender " + tender.ID + " was not published by " + args[0])
+	}
+	if tender.Status != tenderOpen && tender.Status != tenderAwarded {
+		return nil, errors.New("Tender " + tender.ID + " is " + tender.Status)
+	}
+
+	if tender.Status == tenderAwarded && tender.LockFunds {
+		err = releaseHold(stub, tender.NGO, tender.AwardAmount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tender.Status = tenderCancelled
+	return nil, putTender(stub, tender)
+}
+
+//===========================end============tender functions=================================================
+
+//===========================start============tender queries=================================================
+// getTenderStatus returns a tender, its phase at the given time and its bids.
+// Bid amounts stay hidden until they are revealed.
+// args: tender id, as of (ms)
+func getTenderStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting tender id and as of time")
+	}
+
+	asOf, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid as of time " + args[1])
+	}
+	tender, err := getTender(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	bids, err := getBids(stub, tender.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(TenderStatus{Tender: tender, Phase: tenderPhase(tender, asOf), Bids: bids})
+}
+
+// getTenders lists the tenders an NGO published or a vendor bid on
+// args: account
+func getTenders(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	var tenders []Tender
+	err := scanPrefix(stub, tenderPrefix, func(key string, value []byte) error {
+		var tender Tender
+		err := json.Unmarshal(value, &tender)
+		if err != nil {
+			return errors.New("Error unmarshalling tender " + key)
+		}
+		tenders = append(tenders, tender)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Tender{}
+	for _, tender := range tenders {
+		if tender.NGO != args[0] {
+			bidBytes, err := stub.GetState(bidPrefix + tender.ID + ":" + args[0])
+			if err != nil || len(bidBytes) == 0 {
+				continue
+			}
+		}
+		result = append(result, tender)
+	}
+
+	return json.Marshal(result)
+}
+
+//===========================end============tender queries=================================================
@@ -0,0 +1,63 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func bidCommitment(amount string, salt string) string {
+	hash := sha256.Sum256([]byte(amount + ":" + salt))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestAwardTenderToLowestRevealedBid(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	s.openAccount("canvas", "VENDOR", "0")
+	id := string(s.mustInvoke("relief", "createTender", "relief", "tents", "hash", "2000000", "3000000", "true"))
+	s.mustInvoke("tentco", "commitBid", "tentco", id, bidCommitment("500", "salt1"))
+	s.mustInvoke("canvas", "commitBid", "canvas", id, bidCommitment("400", "salt2"))
+
+	s.now = 2000000
+	s.mustInvoke("tentco", "revealBid", "tentco", id, "500", "salt1")
+	s.mustInvoke("canvas", "revealBid", "canvas", id, "400", "salt2")
+	s.now = 3000000
+	s.mustInvoke("relief", "awardTender", "relief", id)
+	s.mustInvoke("relief", "settleTender", "relief", id)
+	if s.balance("canvas") != 400 || s.balance("tentco") != 0 || s.balance("relief") != 600 {
+		t.Errorf("tender paid canvas %v and tentco %v, want 400 to canvas", s.balance("canvas"), s.balance("tentco"))
+	}
+}
+
+func TestRevealBidRefusesChangedAmount(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("tentco", "VENDOR", "0")
+	id := string(s.mustInvoke("relief", "createTender", "relief", "tents", "hash", "2000000", "3000000", "true"))
+	s.mustInvoke("tentco", "commitBid", "tentco", id, bidCommitment("500", "salt1"))
+	s.mustFail("tentco", "revealBid", "tentco", id, "500", "salt1")
+
+	s.now = 2000000
+	s.mustFail("tentco", "commitBid", "tentco", id, bidCommitment("300", "salt1"))
+	s.mustFail("tentco", "revealBid", "tentco", id, "300", "salt1")
+	s.mustFail("relief", "awardTender", "relief", id)
+}
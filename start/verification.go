@@ -0,0 +1,325 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========verification records===============
+var verificationPrefix = "kyc:"
+var verificationLogPrefix = "kyclog:"
+var verifierPrefix = "verifier:"
+var verificationRequiredPrefix = "cfg:kycRequired:"
+
+const (
+	verificationUnverified = "UNVERIFIED"
+	verificationPending    = "PENDING"
+	verificationVerified   = "VERIFIED"
+	verificationRejected   = "REJECTED"
+)
+
+// Verification is an account's KYC status. Evidence collects the hashes of every document
+// submitted in support of it.
+type Verification struct {
+	Account   string   `json:"account"`
+	Status    string   `json:"status"`
+	Evidence  []string `json:"evidence"`
+	Note      string   `json:"note,omitempty"`
+	SetBy     string   `json:"setBy,omitempty"`
+	UpdatedAt int64    `json:"updatedAt,omitempty"`
+}
+
+// VerificationChange records one status change
+type VerificationChange struct {
+	ID        string   `json:"id"`
+	Account   string   `json:"account"`
+	OldStatus string   `json:"oldStatus"`
+	NewStatus string   `json:"newStatus"`
+	Evidence  []string `json:"evidence,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	SetBy     string   `json:"setBy"`
+	Time      int64    `json:"time"`
+}
+
+type VerificationDetail struct {
+	Verification Verification         `json:"verification"`
+	Required     bool                 `json:"required"`
+	History      []VerificationChange `json:"history"`
+}
+
+//============end==========verification records===============
+
+// getVerification returns an account's verification, UNVERIFIED when nothing was recorded
+func getVerification(stub shim.ChaincodeStubInterface, accountID string) (Verification, error) {
+	verification := Verification{Account: accountID, Status: verificationUnverified, Evidence: []string{}}
+	verificationBytes, err := stub.GetState(verificationPrefix + accountID)
+	if err != nil {
+		return verification, errors.New("Error reading verification of " + accountID)
+	}
+	if len(verificationBytes) == 0 {
+		return verification, nil
+	}
+	err = json.Unmarshal(verificationBytes, &verification)
+	if err != nil {
+		return verification, errors.New("Error unmarshalling verification of " + accountID)
+	}
+	return verification, nil
+}
+
+func verificationRequired(stub shim.ChaincodeStubInterface, usertype string) (bool, error) {
+	requiredBytes, err := stub.GetState(verificationRequiredPrefix + usertype)
+	if err != nil {
+		return false, errors.New("Error reading verification requirement of " + usertype)
+	}
+	return string(requiredBytes) == "true", nil
+}
+
+// checkVerified refuses accounts whose type requires verification and that aren't VERIFIED
+func checkVerified(stub shim.ChaincodeStubInterface, accountID string) error {
+	account, err := GetCompany(accountID, stub)
+	if err != nil {
+		return err
+	}
+	required, err := verificationRequired(stub, accountType(account))
+	if err != nil || !required {
+		return err
+	}
+	verification, err := getVerification(stub, accountID)
+	if err != nil {
+		return err
+	}
+	if verification.Status != verificationVerified {
+		fmt.Println("===================Account " + accountID + " is " + verification.Status)
+		return errors.New("Account " + accountID + " is not verified, its status is " + verification.Status)
+	}
+	return nil
+}
+
+// isVerifier checks an account may set verification statuses: admins and designated verifiers
+func isVerifier(stub shim.ChaincodeStubInterface, accountID string) (bool, error) {
+	admin, err := isAdmin(stub, accountID)
+	if err != nil || admin {
+		return admin, err
+	}
+	verifierBytes, err := stub.GetState(verifierPrefix + accountID)
+	if err != nil {
+		return false, errors.New("Error reading verifier role of " + accountID)
+	}
+	return len(verifierBytes) > 0, nil
+}
+
+//===========================start============verification functions=================================================
+// setVerifier gives an account the VERIFIER role, or takes it away
+// args: admin, account, true|false
+func (t *SimpleChaincode) setVerifier(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting verifier.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, account and true or false")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set verifier for " + args[0])
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if args[2] == "true" {
+		err = stub.PutState(verifierPrefix+args[1], []byte(args[0]))
+	} else if args[2] == "false" {
+		err = stub.DelState(verifierPrefix + args[1])
+	} else {
+		return nil, errors.New("Expecting true or false, not " + args[2])
+	}
+	if err != nil {
+		return nil, errors.New("Error writing verifier role of " + args[1])
+	}
+	return nil, nil
+}
+
+// setVerificationRequired makes transactions and mints refuse unverified accounts of a type
+// args: admin, account type, true|false
+func (t *SimpleChaincode) setVerificationRequired(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting verification requirement.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, account type and true or false")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set verification requirement for " + args[0])
+	}
+	if args[1] != "ADMIN" && args[1] != "CORPORATE" && args[1] != "NGO" && args[1] != "VENDOR" {
+		return nil, errors.New("Invalid account type")
+	}
+
+	if args[2] == "true" {
+		err = stub.PutState(verificationRequiredPrefix+args[1], []byte("true"))
+	} else if args[2] == "false" {
+		err = stub.DelState(verificationRequiredPrefix + args[1])
+	} else {
+		return nil, errors.New("Expecting true or false, not " + args[2])
+	}
+	if err != nil {
+		return nil, errors.New("Error writing verification requirement of " + args[1])
+	}
+	return nil, nil
+}
+
+// setVerificationStatus records an account's KYC status with the hashes of the evidence
+// behind it. Verifying needs evidence, and nobody sets their own status.
+// args: verifier, account, status (UNVERIFIED, PENDING, VERIFIED or REJECTED), evidence hashes (JSON array)[, note]
+func (t *SimpleChaincode) setVerificationStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting verification status.=========================")
+
+	if len(args) != 4 && len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting verifier, account, status, evidence hashes and optional note")
+	}
+
+	allowed, err := isVerifier(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errors.New("Invalid Reuest to set verification status for " + args[0])
+	}
+	if args[0] == args[1] {
+		return nil, errors.New("An account can't set its own verification status")
+	}
+	_, err = GetCompany(args[1], stub)
+	if err != nil {
+		return nil, err
+	}
+
+	status := args[2]
+	if status != verificationUnverified && status != verificationPending && status != verificationVerified && status != verificationRejected {
+		return nil, errors.New("Invalid verification status " + status)
+	}
+	var evidence []string
+	err = json.Unmarshal([]byte(args[3]), &evidence)
+	if err != nil {
+		return nil, errors.New("Invalid evidence hashes " + args[3])
+	}
+	for _, hash := range evidence {
+		if hash == "" {
+			return nil, errors.New("Evidence hashes can't be empty")
+		}
+	}
+
+	verification, err := getVerification(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range evidence {
+		if !listContains(verification.Evidence, hash) {
+			verification.Evidence = append(verification.Evidence, hash)
+		}
+	}
+	if status == verificationVerified && len(verification.Evidence) == 0 {
+		return nil, errors.New("Verifying " + args[1] + " needs at least one evidence hash")
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	changeID, err := nextID(stub, "KYC")
+	if err != nil {
+		return nil, err
+	}
+	change := VerificationChange{ID: changeID, Account: args[1], OldStatus: verification.Status, NewStatus: status, Evidence: evidence, SetBy: args[0], Time: now}
+	verification.Status = status
+	verification.SetBy = args[0]
+	verification.UpdatedAt = now
+	verification.Note = ""
+	if len(args) == 5 {
+		verification.Note = args[4]
+		change.Note = args[4]
+	}
+
+	changeBytes, err := json.Marshal(&change)
+	if err != nil {
+		return nil, errors.New("Error marshalling verification change " + changeID)
+	}
+	err = stub.PutState(verificationLogPrefix+args[1]+":"+changeID, changeBytes)
+	if err != nil {
+		return nil, errors.New("Error writing verification change " + changeID)
+	}
+	verificationBytes, err := json.Marshal(&verification)
+	if err != nil {
+		return nil, errors.New("Error marshalling verification of " + args[1])
+	}
+	err = stub.PutState(verificationPrefix+args[1], verificationBytes)
+	if err != nil {
+		return nil, errors.New("Error writing verification of " + args[1])
+	}
+
+	fmt.Println("==================***=== " + args[1] + " is now " + status + " ====***====================")
+	return nil, nil
+}
+
+//===========================end============verification functions=================================================
+
+//===========================start============verification queries=================================================
+// getVerificationStatus returns an account's verification, whether its type requires it,
+// and every change made to it
+// args: account
+func getVerificationStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting account")
+	}
+
+	account, err := GetCompany(args[0], stub)
+	if err != nil {
+		return nil, err
+	}
+	detail := VerificationDetail{History: []VerificationChange{}}
+	detail.Verification, err = getVerification(stub, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	detail.Required, err = verificationRequired(stub, accountType(account))
+	if err != nil {
+		return nil, err
+	}
+	err = scanPrefix(stub, verificationLogPrefix+account.ID+":", func(key string, value []byte) error {
+		var change VerificationChange
+		err := json.Unmarshal(value, &change)
+		if err != nil {
+			return errors.New("Error unmarshalling verification change " + key)
+		}
+		detail.History = append(detail.History, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(detail)
+}
+
+//===========================end============verification queries=================================================
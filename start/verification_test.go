@@ -0,0 +1,54 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestVerifiedAccountCanTransact(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("checker", "CORPORATE", "0")
+	s.mustInvoke("root", "setVerificationRequired", "root", "NGO", "true")
+	s.mustInvoke("root", "setVerifier", "root", "checker", "true")
+
+	s.mustInvoke("checker", "setVerificationStatus", "checker", "relief", "VERIFIED", `["charter"]`, "documents in order")
+	s.mustInvoke("acme", "transaction", "acme", "relief", "10", "gift")
+	if s.balance("relief") != 10 {
+		t.Errorf("verified relief received %v, want 10", s.balance("relief"))
+	}
+}
+
+func TestUnverifiedAccountRefused(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("acme", "CORPORATE", "100")
+	s.openAccount("relief", "NGO", "0")
+	s.openAccount("checker", "CORPORATE", "0")
+	s.mustInvoke("root", "setVerificationRequired", "root", "NGO", "true")
+
+	s.mustFail("acme", "transaction", "acme", "relief", "10", "gift")
+	s.mustFail("root", "mint", "root", "relief", "10")
+	s.mustFail("checker", "setVerificationStatus", "checker", "relief", "VERIFIED", `["charter"]`)
+	s.mustInvoke("root", "setVerifier", "root", "checker", "true")
+	s.mustFail("checker", "setVerificationStatus", "checker", "relief", "VERIFIED", `[]`)
+	s.mustInvoke("checker", "setVerificationStatus", "checker", "relief", "PENDING", `["charter"]`)
+	s.mustFail("acme", "transaction", "acme", "relief", "10", "gift")
+	if s.balance("relief") != 0 {
+		t.Errorf("unverified relief received %v", s.balance("relief"))
+	}
+}
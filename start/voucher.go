@@ -0,0 +1,374 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//============start==========voucher records===============
+var voucherPrefix = "voucher:"
+
+const (
+	voucherActive   = "ACTIVE"
+	voucherRedeemed = "REDEEMED"
+	voucherExpired  = "EXPIRED"
+)
+
+// Voucher is aid issued by an NGO to a beneficiary. The unredeemed value stays held on the
+// NGO account until it is redeemed at a vendor or swept back after expiry.
+type Voucher struct {
+	ID             string   `json:"id"`
+	NGO            string   `json:"ngo"`
+	Beneficiary    string   `json:"beneficiary"`
+	Value          float64  `json:"value"`
+	Remaining      float64  `json:"remaining"`
+	Expiry         int64    `json:"expiry"`
+	AllowedVendors []string `json:"allowedVendors,omitempty"`
+	VendorCategory string   `json:"vendorCategory,omitempty"`
+	Status         string   `json:"status"`
+	IssuedAt       int64    `json:"issuedAt"`
+}
+
+//============end==========voucher records===============
+
+func getVoucher(stub shim.ChaincodeStubInterface, voucherID string) (Voucher, error) {
+	var voucher Voucher
+	voucherBytes, err := stub.GetState(voucherPrefix + voucherID)
+	if err != nil || len(voucherBytes) == 0 {
+		fmt.Println("Voucher not found " + voucherID)
+		return voucher, errors.New("Voucher not found " + voucherID)
+	}
+
+	err = json.Unmarshal(voucherBytes, &voucher)
+	if err != nil {
+		fmt.Println("Error unmarshalling voucher " + voucherID + "\n err:" + err.Error())
+		return voucher, errors.New("Error unmarshalling voucher " + voucherID)
+	}
+	return voucher, nil
+}
+
+func putVoucher(stub shim.ChaincodeStubInterface, voucher Voucher) error {
+	voucherBytes, err := json.Marshal(&voucher)
+	if err != nil {
+		fmt.Println("Error marshalling voucher " + voucher.ID)
+		return errors.New("Error marshalling voucher " + voucher.ID)
+	}
+	err = stub.PutState(voucherPrefix+voucher.ID, voucherBytes)
+	if err != nil {
+		fmt.Println("Error writing voucher " + voucher.ID)
+		return errors.New("Error writing voucher " + voucher.ID)
+	}
+	return nil
+}
+
+// getVendorCategory returns the category an admin set on a vendor's profile, or "" if none
+func getVendorCategory(stub shim.ChaincodeStubInterface, vendor string) (string, error) {
+	profile, err := getProfile(stub, vendor)
+	if err != nil || profile == nil {
+		return "", err
+	}
+	return profile.Category, nil
+}
+
+// voucherAcceptedBy checks a vendor against the voucher's vendor list and category
+func voucherAcceptedBy(stub shim.ChaincodeStubInterface, voucher Voucher, vendor string) (bool, error) {
+	if len(voucher.AllowedVendors) > 0 {
+		listed := false
+		for _, allowed := range voucher.AllowedVendors {
+			if allowed == vendor {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			return false, nil
+		}
+	}
+	if voucher.VendorCategory != "" {
+		category, err := getVendorCategory(stub, vendor)
+		if err != nil {
+			return false, err
+		}
+		if category != voucher.VendorCategory {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+//===========================start============voucher functions=================================================
+// setVendorCategory lets an admin file a vendor under a category vouchers can be restricted to
+// args: admin, vendor, category
+func (t *SimpleChaincode) setVendorCategory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Setting vendor category.=========================")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting admin, vendor and category")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "ADMIN")
+	if err != nil {
+		return nil, errors.New("Invalid Reuest to set vendor category for " + args[0])
+	}
+	_, err = getAccountOfType(stub, args[1], "VENDOR")
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, applyProfileUpdate(stub, args[0], args[1], map[string]string{"category": args[2]})
+}
+
+// issueVoucher creates a voucher for a beneficiary and holds its value on the NGO account
+// args: ngo, beneficiary, value, expiry (ms), allowed vendors (JSON array, may be empty), vendor category (may be empty)
+func (t *SimpleChaincode) issueVoucher(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Issuing voucher.=========================")
+
+	if len(args) != 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo, beneficiary, value, expiry, allowed vendors and vendor category")
+	}
+
+	_, err := getAccountOfType(stub, args[0], "NGO")
+	if err != nil {
+		return nil, err
+	}
+	if args[1] == "" {
+		return nil, errors.New("Beneficiary is required")
+	}
+	value, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || value <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[2])
+		return nil, errors.New("Invalid Amount " + args[2])
+	}
+	expiry, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid expiry " + args[3])
+	}
+
+	var allowedVendors []string
+	if args[4] != "" {
+		err = json.Unmarshal([]byte(args[4]), &allowedVendors)
+		if err != nil {
+			return nil, errors.New("Invalid allowed vendors " + args[4])
+		}
+		for _, vendor := range allowedVendors {
+			_, err = getAccountOfType(stub, vendor, "VENDOR")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if expiry <= now {
+		return nil, errors.New("Voucher expiry must be in the future")
+	}
+
+	// Multisig accounts can't hold funds for a payment their signers haven't approved
+	err = checkSingleSigner(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = placeHold(stub, args[0], value)
+	if err != nil {
+		return nil, err
+	}
+
+	voucherID, err := nextID(stub, "VCH")
+	if err != nil {
+		return nil, err
+	}
+	voucher := Voucher{
+		ID:             voucherID,
+		NGO:            args[0],
+		Beneficiary:    args[1],
+		Value:          value,
+		Remaining:      value,
+		Expiry:         expiry,
+		AllowedVendors: allowedVendors,
+		VendorCategory: args[5],
+		Status:         voucherActive,
+		IssuedAt:       now,
+	}
+	err = putVoucher(stub, voucher)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Voucher " + voucherID + " issued ====***====================")
+	return []byte(voucherID), nil
+}
+
+// redeemVoucher spends some or all of a voucher at a vendor, who is paid from the NGO's held funds
+// args: beneficiary, voucher id, vendor, amount
+func (t *SimpleChaincode) redeemVoucher(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Redeeming voucher.=========================")
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting beneficiary, voucher id, vendor and amount")
+	}
+
+	voucher, err := getVoucher(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if voucher.Beneficiary != args[0] {
+		return nil, errors.New("Voucher " + voucher.ID + " was not issued to " + args[0])
+	}
+	if voucher.Status != voucherActive {
+		return nil, errors.New("Voucher " + voucher.ID + " is " + voucher.Status)
+	}
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now >= voucher.Expiry {
+		return nil, errors.New("Voucher " + voucher.ID + " has expired")
+	}
+
+	_, err = getAccountOfType(stub, args[2], "VENDOR")
+	if err != nil {
+		return nil, err
+	}
+	accepted, err := voucherAcceptedBy(stub, voucher, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if !accepted {
+		return nil, errors.New("Voucher " + voucher.ID + " can't be redeemed at " + args[2])
+	}
+
+	amount, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || amount <= 0.0 {
+		fmt.Println("===============Invalid Amount " + args[3])
+		return nil, errors.New("Invalid Amount " + args[3])
+	}
+	if amount > voucher.Remaining+0.005 {
+		return nil, errors.New("Voucher " + voucher.ID + " only has " + strconv.FormatFloat(voucher.Remaining, 'f', 2, 64) + " left")
+	}
+
+	err = releaseHold(stub, voucher.NGO, amount)
+	if err != nil {
+		return nil, err
+	}
+	_, err = transferFunds(stub, voucher.NGO, args[2], amount, "Voucher "+voucher.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	voucher.Remaining -= amount
+	if voucher.Remaining < 0.005 {
+		voucher.Remaining = 0
+		voucher.Status = voucherRedeemed
+	}
+	err = putVoucher(stub, voucher)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("==================***=== Voucher " + voucher.ID + " redeemed at " + args[2] + " ====***====================")
+	return nil, nil
+}
+
+// sweepExpiredVouchers returns the unredeemed value of expired vouchers to their NGOs.
+// Anyone may call it; an optional ngo argument limits the sweep to that NGO's vouchers.
+// args: [ngo]
+func (t *SimpleChaincode) sweepExpiredVouchers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	fmt.Println("====================Sweeping expired vouchers.=========================")
+
+	if len(args) > 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting optional ngo")
+	}
+
+	now, err := txTimestampMs(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []Voucher
+	err = scanPrefix(stub, voucherPrefix, func(key string, value []byte) error {
+		var voucher Voucher
+		err := json.Unmarshal(value, &voucher)
+		if err != nil {
+			return errors.New("Error unmarshalling voucher " + key)
+		}
+		if voucher.Status == voucherActive && voucher.Expiry <= now && (len(args) == 0 || voucher.NGO == args[0]) {
+			expired = append(expired, voucher)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	swept := []string{}
+	for _, voucher := range expired {
+		err = releaseHold(stub, voucher.NGO, voucher.Remaining)
+		if err != nil {
+			return nil, err
+		}
+		voucher.Status = voucherExpired
+		err = putVoucher(stub, voucher)
+		if err != nil {
+			return nil, err
+		}
+		swept = append(swept, voucher.ID)
+	}
+
+	fmt.Println("==================***=== Swept " + strconv.Itoa(len(swept)) + " expired vouchers ====***====================")
+	return json.Marshal(swept)
+}
+
+//===========================end============voucher functions=================================================
+
+//===========================start============voucher queries=================================================
+// getVouchers lists the vouchers an NGO issued or a beneficiary holds
+// args: ngo or beneficiary
+func getVouchers(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting ngo or beneficiary")
+	}
+
+	vouchers := []Voucher{}
+	err := scanPrefix(stub, voucherPrefix, func(key string, value []byte) error {
+		var voucher Voucher
+		err := json.Unmarshal(value, &voucher)
+		if err != nil {
+			return errors.New("Error unmarshalling voucher " + key)
+		}
+		if voucher.NGO == args[0] || voucher.Beneficiary == args[0] {
+			vouchers = append(vouchers, voucher)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(vouchers)
+}
+
+//===========================end============voucher queries=================================================
@@ -0,0 +1,51 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestRedeemVoucherAtCategoryVendor(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("grocer", "VENDOR", "0")
+	s.mustInvoke("root", "setVendorCategory", "root", "grocer", "FOOD")
+	id := string(s.mustInvoke("relief", "issueVoucher", "relief", "amina", "300", "9000000", "", "FOOD"))
+
+	s.mustInvoke("amina", "redeemVoucher", "amina", id, "grocer", "100")
+	if s.balance("grocer") != 100 || s.balance("relief") != 900 {
+		t.Errorf("grocer was paid %v for a 100 redemption", s.balance("grocer"))
+	}
+	s.mustFail("relief", "transaction", "relief", "grocer", "800", "spend the voucher reserve")
+}
+
+func TestRedeemVoucherRefusesOtherVendors(t *testing.T) {
+	s := newTestStub(t)
+	s.openAccount("relief", "NGO", "1000")
+	s.openAccount("grocer", "VENDOR", "0")
+	s.openAccount("garage", "VENDOR", "0")
+	s.mustInvoke("root", "setVendorCategory", "root", "grocer", "FOOD")
+	id := string(s.mustInvoke("relief", "issueVoucher", "relief", "amina", "300", "2000000", "", "FOOD"))
+
+	s.mustFail("amina", "redeemVoucher", "amina", id, "garage", "100")
+	s.mustFail("amina", "redeemVoucher", "amina", id, "grocer", "400")
+	s.now = 3000000
+	s.mustFail("amina", "redeemVoucher", "amina", id, "grocer", "100")
+	if s.balance("garage") != 0 || s.balance("grocer") != 0 {
+		t.Errorf("voucher was redeemed outside its terms")
+	}
+}